@@ -0,0 +1,7 @@
+package main
+
+import (
+	"flag"
+)
+
+var g_archive = flag.String("archive", "", "tar file where the outputs of all the slaves are streamed (as <slave>/<output>), instead of the output directory")
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+var g_baseline = flag.String("baseline", "", "(JSON) manifest of the known-good outputs of each slave")
+var g_update_baseline = flag.Bool("update-baseline", false, "update the -baseline manifest with the outputs of the successful builds")
+
+// baselines holds the content of the -baseline manifest.
+var baselines bldbot.Baseline
@@ -0,0 +1,150 @@
+package bldbot
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Archive is a tar archive the outputs of the builds are streamed into
+// (-archive), as <slave>/<output>.
+// Entries are written one at a time, whole.
+type Archive struct {
+	mu sync.Mutex
+	tw *tar.Writer
+	f  io.Closer // file created by CreateArchive, if any
+}
+
+// NewArchive returns an archive written to w.
+func NewArchive(w io.Writer) *Archive {
+	return &Archive{tw: tar.NewWriter(w)}
+}
+
+// CreateArchive creates the archive file fname.
+func CreateArchive(fname string) (*Archive, error) {
+	f, err := os.Create(fname)
+	if err != nil {
+		return nil, err
+	}
+	a := NewArchive(f)
+	a.f = f
+	return a, nil
+}
+
+// Close writes the end of the archive, and closes its file if it was
+// created by CreateArchive.
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.tw == nil {
+		return nil
+	}
+	err := a.tw.Close()
+	if a.f != nil {
+		if cerr := a.f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	a.tw = nil
+	return err
+}
+
+// archiveOutputs streams the outputs of the build from the slave (with the
+// remote tar) into the archive, and returns their entries.
+func (b Builder) archiveOutputs(outputs []string) ([]ArtifactInfo, error) {
+	args := make([]string, 0, 2*len(outputs))
+	for _, output := range outputs {
+		args = append(args, "-C", ShellQuote(path.Dir(output)), ShellQuote(path.Base(output)))
+	}
+	cmd := "tar cf - " + strings.Join(args, " ")
+
+	pr, pw := io.Pipe()
+	type result struct {
+		artifacts []ArtifactInfo
+		err       error
+	}
+	done := make(chan result, 1)
+	go func() {
+		artifacts, err := b.opts.Archive.stream(pr, b.slave.Name)
+		// drain the stream if the copy stopped early.
+		io.Copy(ioutil.Discard, pr)
+		done <- result{artifacts, err}
+	}()
+	err := b.t.Run(b.ctx, cmd, nil, pw, b.w)
+	pw.CloseWithError(err)
+	res := <-done
+	if err != nil {
+		return res.artifacts, err
+	}
+	return res.artifacts, res.err
+}
+
+// stream copies the regular files of the tar stream r into the archive, as
+// <slave>/<base name>.
+func (a *Archive) stream(r io.Reader, slave string) ([]ArtifactInfo, error) {
+	var artifacts []ArtifactInfo
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return artifacts, nil
+		}
+		if err != nil {
+			return artifacts, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Join(slave, path.Base(hdr.Name))
+		sum, err := a.entry(name, hdr, tr)
+		if err != nil {
+			return artifacts, fmt.Errorf("could not archive [%s] (%v)", name, err)
+		}
+		artifacts = append(artifacts, ArtifactInfo{Path: name, Size: hdr.Size, SHA256: sum})
+	}
+}
+
+// entry writes the entry name, of header hdr and content r, into the
+// archive and returns its SHA-256 hash.
+// An entry cut short is padded with zeros, which keeps the other entries of
+// the archive readable.
+func (a *Archive) entry(name string, hdr *tar.Header, r io.Reader) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.tw == nil {
+		return "", fmt.Errorf("archive closed")
+	}
+	err := a.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    hdr.Mode,
+		Size:    hdr.Size,
+		ModTime: hdr.ModTime,
+	})
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(a.tw, h), r)
+	if err != nil {
+		io.CopyN(a.tw, zeros{}, hdr.Size-n)
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// zeros is an endless stream of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,154 @@
+package bldbot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Baseline maps a slave name to the outputs of its known-good build.
+type Baseline map[string][]baselineEntry
+
+type baselineEntry struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// LoadBaseline reads the baseline manifest from fname.
+// A missing file yields an empty baseline when it is to be updated.
+func LoadBaseline(fname string, update bool) (Baseline, error) {
+	bl := make(Baseline)
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		if os.IsNotExist(err) && update {
+			return bl, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(buf, &bl)
+	if err != nil {
+		return nil, err
+	}
+	return bl, nil
+}
+
+func (bl Baseline) Save(fname string) error {
+	buf, err := json.MarshalIndent(bl, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fname, append(buf, '\n'), 0644)
+}
+
+// Update records the outputs of the successful builds as the new baseline.
+func (bl Baseline) Update(reports []BuildReport) error {
+	for _, report := range reports {
+		if report.Status() != StatusOK || !report.InOutputDir() {
+			continue
+		}
+		entries, err := baselineEntries(report.Outputs)
+		if err != nil {
+			return err
+		}
+		bl[report.Slave.Name] = entries
+	}
+	return nil
+}
+
+// baselineEntries returns the (sorted by name) baseline entries of the
+// local output files.
+func baselineEntries(outputs []string) ([]baselineEntry, error) {
+	entries := make([]baselineEntry, 0, len(outputs))
+	for _, fname := range outputs {
+		sum, size, err := HashFile(fname)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, baselineEntry{
+			Name:   filepath.Base(fname),
+			Size:   size,
+			SHA256: sum,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// checkBaseline compares the outputs of the build with the baseline of the
+// slave. Missing or unexpected outputs fail the build, changes of size or
+// content are only reported.
+func (b Builder) checkBaseline(report *BuildReport) {
+	want, ok := b.opts.Baseline[b.slave.Name]
+	if !ok {
+		fmt.Fprintf(b.w, "## build -- no baseline for slave [%s]\n", b.slave.Name)
+		return
+	}
+	got, err := baselineEntries(report.Outputs)
+	if err != nil {
+		report.Msg = "could not hash outputs"
+		report.Err = err
+		return
+	}
+
+	wants := make(map[string]baselineEntry, len(want))
+	for _, e := range want {
+		wants[e.Name] = e
+	}
+	gots := make(map[string]baselineEntry, len(got))
+	for _, e := range got {
+		gots[e.Name] = e
+	}
+
+	diffs := make([]string, 0)
+	bad := 0
+	for _, e := range want {
+		if _, ok := gots[e.Name]; !ok {
+			diffs = append(diffs, fmt.Sprintf("- %s (missing)", e.Name))
+			bad++
+		}
+	}
+	for _, e := range got {
+		ref, ok := wants[e.Name]
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("+ %s (unexpected)", e.Name))
+			bad++
+		case ref.Size != e.Size:
+			diffs = append(diffs, fmt.Sprintf("~ %s (size %d -> %d)", e.Name, ref.Size, e.Size))
+		case ref.SHA256 != e.SHA256:
+			diffs = append(diffs, fmt.Sprintf("~ %s (content changed)", e.Name))
+		}
+	}
+
+	for _, diff := range diffs {
+		fmt.Fprintf(b.w, "## build -- baseline: %s\n", diff)
+		log.Printf("slave [%s]: baseline: %s\n", b.slave.Name, diff)
+	}
+	if bad > 0 {
+		report.Msg = "outputs differ from baseline"
+		report.Err = fmt.Errorf("%d output(s) missing or unexpected w.r.t. baseline", bad)
+	}
+}
+
+// HashFile returns the (hex-encoded) sha256 and the size of a file.
+func HashFile(fname string) (string, int64, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
@@ -0,0 +1,109 @@
+package bldbot
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// TokenBucket is a token bucket rate limiter, of rate bytes (or
+// connections) per second, shared by concurrent users.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a token bucket of the given rate.
+func NewTokenBucket(rate int) *TokenBucket {
+	return &TokenBucket{
+		rate:  float64(rate),
+		burst: float64(rate) / 4, // do not let a transfer hog 1s worth of bandwidth
+		last:  time.Now(),
+	}
+}
+
+// take blocks until n bytes (at most the burst size of the bucket) may be
+// transferred.
+func (tb *TokenBucket) take(n int) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+	tb.tokens -= float64(n)
+	if tb.tokens < 0 {
+		// holding the lock while sleeping queues up the other transfers.
+		wait := time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+		time.Sleep(wait)
+		tb.last = tb.last.Add(wait)
+		tb.tokens = 0
+	}
+}
+
+// chunk returns the largest number of bytes taken at once from the bucket.
+func (tb *TokenBucket) chunk() int {
+	n := int(tb.burst)
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// limitedWriter is a writer whose throughput is limited by a token bucket.
+type limitedWriter struct {
+	w  io.Writer
+	tb *TokenBucket
+}
+
+func (lw limitedWriter) Write(data []byte) (int, error) {
+	n := 0
+	for len(data) > 0 {
+		sz := lw.tb.chunk()
+		if sz > len(data) {
+			sz = len(data)
+		}
+		lw.tb.take(sz)
+		nn, err := lw.w.Write(data[:sz])
+		n += nn
+		if err != nil {
+			return n, err
+		}
+		data = data[sz:]
+	}
+	return n, nil
+}
+
+// retrieveLimited retrieves the remote files srcs into the local directory
+// dst, streaming them (with cat) through the Bandwidth bucket shared by all
+// the retrievals of the run.
+func (b Builder) retrieveLimited(srcs []string, dst string) error {
+	for _, src := range srcs {
+		fname := filepath.Join(dst, path.Base(src))
+		f, err := os.Create(fname)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b.w, "## build -- retrieving [%s] (total-bwlimit=%dKB/s)...\n", src, int(b.opts.Bandwidth.rate)/1024)
+		w := limitedWriter{w: f, tb: b.opts.Bandwidth}
+		err = b.t.Run(b.ctx, "cat "+ShellQuote(src), nil, w, b.w)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		err = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
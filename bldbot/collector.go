@@ -0,0 +1,69 @@
+package bldbot
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Collector is a host the outputs of the builds are sent to, straight from
+// the slaves: they do not transit through the local machine.
+// The slave must be able to log into the collector (with ssh.)
+type Collector struct {
+	Addr  string // collector SSH address
+	Path  string // directory under which the outputs are stored, in <Path>/<slave>
+	Rsync bool   // copy the outputs with rsync instead of scp
+}
+
+// OutputCollector returns the collector the outputs of that slave are sent to, or
+// nil if they are retrieved locally, def (addr:/path) being that of the
+// slaves without a Collector.
+func (s *Slave) OutputCollector(def string) (*Collector, error) {
+	c := s.Collector
+	if c == nil && def != "" {
+		i := strings.Index(def, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid collector [%s] (want addr:/path)", def)
+		}
+		c = &Collector{Addr: def[:i], Path: def[i+1:]}
+	}
+	if c == nil {
+		return nil, nil
+	}
+	if c.Addr == "" {
+		return nil, fmt.Errorf("collector has no address")
+	}
+	err := CheckRemotePath(c.Path)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// collect copies the remote outputs from the slave to the collector c and
+// returns their locations (addr:path) there.
+func (b Builder) collect(c *Collector, outputs []string) ([]string, error) {
+	dir := path.Join(c.Path, b.slave.Name)
+	fmt.Fprintf(b.w, "## build -- sending output(s) to collector [%s:%s]...\n", c.Addr, dir)
+	err := NewTransport(&Slave{Addr: c.Addr}, b.opts).Mkdir(b.ctx, dir, b.w)
+	if err != nil {
+		return nil, fmt.Errorf("could not create [%s] on collector [%s] (%v)", dir, c.Addr, err)
+	}
+
+	args := make([]string, 0, len(outputs))
+	dsts := make([]string, 0, len(outputs))
+	for _, output := range outputs {
+		args = append(args, ShellQuote(output))
+		dsts = append(dsts, c.Addr+":"+path.Join(dir, path.Base(output)))
+	}
+	cmd := "scp -q -o BatchMode=yes"
+	if c.Rsync {
+		cmd = "rsync -a"
+	}
+	cmd = fmt.Sprintf("%s %s %s", cmd, strings.Join(args, " "), ShellQuote(c.Addr+":"+dir+"/"))
+	err = b.t.Run(b.ctx, cmd, nil, b.w, b.w)
+	if err != nil {
+		return nil, err
+	}
+	return dsts, nil
+}
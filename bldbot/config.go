@@ -0,0 +1,142 @@
+package bldbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yml "github.com/gonuts/yaml"
+)
+
+// LoadSlaves reads and merges the lists of slaves of the files given as a
+// comma-separated list of file names or globs, then applies the transforms
+// fs to them, in order (before they are checked, see CheckSlaves.)
+// Slaves are returned in the order of the list (matches of a glob being
+// sorted), and in the order of each file. Names must be unique across all
+// the files, and still after each transform.
+func LoadSlaves(spec string, fs ...SlaveTransform) ([]Slave, error) {
+	slaves := make([]Slave, 0, 2)
+	owners := make(map[string]string) // slave name -> file
+	for _, pattern := range strings.Split(spec, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		fnames := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) <= 0 {
+				return nil, fmt.Errorf("no file matching [%s]", pattern)
+			}
+			sort.Strings(matches)
+			fnames = matches
+		}
+
+		for _, fname := range fnames {
+			config, err := loadConfig(fname)
+			if err != nil {
+				return nil, err
+			}
+			for _, slave := range config.Slaves {
+				if owner, dup := owners[slave.Name]; dup {
+					return nil, fmt.Errorf(
+						"duplicate slave [%s] in [%s] (already in [%s])",
+						slave.Name, fname, owner,
+					)
+				}
+				owners[slave.Name] = fname
+				slaves = append(slaves, slave)
+			}
+		}
+	}
+	return transformSlaves(slaves, fs)
+}
+
+// loadConfig decodes the (YAML, or JSON for .json files) list of slaves
+// in fname.
+func loadConfig(fname string) (Config, error) {
+	config := Config{
+		Slaves: make([]Slave, 0, 2),
+	}
+	in, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return config, fmt.Errorf("could not open file [%s] (%v)", fname, err)
+	}
+	if strings.ToLower(filepath.Ext(fname)) == ".json" {
+		err = json.Unmarshal(in, &config)
+	} else {
+		err = yml.Unmarshal(in, &config)
+	}
+	if err != nil {
+		return config, fmt.Errorf("could not decode file [%s] (%v)", fname, err)
+	}
+	return config, nil
+}
+
+// CheckSlaves checks the configuration of the slaves (whatever -preflight)
+// and returns the list of all the problems found.
+func CheckSlaves(slaves []Slave) []error {
+	var errs []error
+	for i := range slaves {
+		slave := &slaves[i]
+		if slave.Image == "" && (slave.CPUs != "" || slave.Memory != "") {
+			errs = append(errs, fmt.Errorf("slave [%s]: CPUs and Memory require an Image", slave.Name))
+		}
+		if slave.Image != "" && slave.Sandbox != "" {
+			errs = append(errs, fmt.Errorf("slave [%s]: Image and Sandbox are mutually exclusive", slave.Name))
+		}
+		if slave.SandboxRoot != "" && (slave.Sandbox == "" || !path.IsAbs(slave.SandboxRoot)) {
+			errs = append(errs, fmt.Errorf("slave [%s]: SandboxRoot must be an absolute path, with a Sandbox", slave.Name))
+		}
+		for pattern, name := range slave.OutputTypes {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("slave [%s]: invalid OutputTypes pattern %q", slave.Name, pattern))
+			}
+			if _, ok := fileTypes[name]; !ok {
+				errs = append(errs, fmt.Errorf("slave [%s]: unknown OutputTypes type %q", slave.Name, name))
+			}
+		}
+		names := make(map[string]bool, len(slave.Targets))
+		for _, target := range slave.Targets {
+			switch {
+			case target.Name == "" || target.Command == "":
+				errs = append(errs, fmt.Errorf("slave [%s]: Targets need a Name and a Command", slave.Name))
+			case names[target.Name]:
+				errs = append(errs, fmt.Errorf("slave [%s]: duplicate target [%s]", slave.Name, target.Name))
+			}
+			names[target.Name] = true
+		}
+	}
+	return append(errs, checkDependencies(slaves)...)
+}
+
+// SlaveTransform filters, reorders or annotates the slaves decoded by
+// LoadSlaves; e.g. to merge in the live fleet of an inventory system.
+type SlaveTransform func([]Slave) ([]Slave, error)
+
+// transformSlaves applies the transforms fs, in order, to slaves, whose
+// names must still be unique, and returns the first error.
+func transformSlaves(slaves []Slave, fs []SlaveTransform) ([]Slave, error) {
+	for i, f := range fs {
+		var err error
+		slaves, err = f(slaves)
+		if err != nil {
+			return nil, fmt.Errorf("slave transform #%d failed (%v)", i+1, err)
+		}
+		seen := make(map[string]bool, len(slaves))
+		for _, slave := range slaves {
+			if seen[slave.Name] {
+				return nil, fmt.Errorf("duplicate slave [%s] after slave transform #%d", slave.Name, i+1)
+			}
+			seen[slave.Name] = true
+		}
+	}
+	return slaves, nil
+}
@@ -0,0 +1,46 @@
+package bldbot
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSlavesTransforms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-bldbot-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "slaves.json")
+	err = ioutil.WriteFile(fname, []byte(`{"Slaves": [{"Name": "s1"}, {"Name": "s2"}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drop := func(slaves []Slave) ([]Slave, error) {
+		return slaves[1:], nil
+	}
+	add := func(slaves []Slave) ([]Slave, error) {
+		return append(slaves, Slave{Name: "s3"}), nil
+	}
+	got, err := LoadSlaves(fname, drop, add)
+	if err != nil || len(got) != 2 || got[0].Name != "s2" || got[1].Name != "s3" {
+		t.Errorf("LoadSlaves = %v, %v, want [s2 s3]", got, err)
+	}
+
+	dup := func(slaves []Slave) ([]Slave, error) {
+		return append(slaves, slaves[0]), nil
+	}
+	if _, err := LoadSlaves(fname, add, dup); err == nil {
+		t.Errorf("LoadSlaves with duplicate names = nil, want an error")
+	}
+	fail := func(slaves []Slave) ([]Slave, error) {
+		return nil, errors.New("inventory down")
+	}
+	if _, err := LoadSlaves(fname, fail); err == nil {
+		t.Errorf("LoadSlaves with a failing transform = nil, want an error")
+	}
+}
@@ -0,0 +1,61 @@
+package bldbot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// dedupMu serializes the updates of the -dedup-store by the builders.
+var dedupMu sync.Mutex
+
+// dedup moves the retrieved outputs of the report into the content-addressed
+// store (<store>/<sha256[:2]>/<sha256>) and replaces them with hardlinks
+// (or symlinks, across filesystems) to their stored copy.
+// It returns the number of bytes saved by outputs already in the store.
+func (b Builder) dedup(store string, report *BuildReport) (int64, error) {
+	dedupMu.Lock()
+	defer dedupMu.Unlock()
+
+	saved := int64(0)
+	for _, fname := range report.Outputs {
+		sum, size, err := HashFile(fname)
+		if err != nil {
+			return saved, err
+		}
+		dir := filepath.Join(store, sum[:2])
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return saved, err
+		}
+		obj := filepath.Join(dir, sum)
+		if _, err := os.Stat(obj); err == nil {
+			saved += size
+			fmt.Fprintf(b.w, "## build -- dedup: [%s] already stored as [%s]\n", fname, obj)
+		} else {
+			err = os.Rename(fname, obj)
+			if err != nil {
+				err = copyFile(obj, fname)
+			}
+			if err != nil {
+				return saved, err
+			}
+			os.Chmod(obj, 0444)
+		}
+
+		os.Remove(fname)
+		err = os.Link(obj, fname)
+		if err != nil {
+			abs, aerr := filepath.Abs(obj)
+			if aerr != nil {
+				return saved, aerr
+			}
+			err = os.Symlink(abs, fname)
+		}
+		if err != nil {
+			return saved, err
+		}
+	}
+	return saved, nil
+}
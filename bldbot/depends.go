@@ -0,0 +1,82 @@
+package bldbot
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// InputsDir is the directory (relative to Path) where the outputs of the
+// dependencies of a slave are uploaded, under the names of the slaves.
+const InputsDir = "inputs"
+
+// checkDependencies returns the errors of the DependsOn of the slaves:
+// unknown slaves and cycles.
+func checkDependencies(slaves []Slave) []error {
+	var errs []error
+	deps := make(map[string][]string, len(slaves))
+	for _, slave := range slaves {
+		deps[slave.Name] = slave.DependsOn
+	}
+	for _, slave := range slaves {
+		for _, dep := range slave.DependsOn {
+			if _, ok := deps[dep]; !ok {
+				errs = append(errs, fmt.Errorf("slave [%s]: DependsOn unknown slave [%s]", slave.Name, dep))
+			}
+		}
+	}
+
+	// depth-first, in the order of the slaves.
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(slaves))
+	var visit func(name string, chain []string)
+	visit = func(name string, chain []string) {
+		switch state[name] {
+		case visiting:
+			errs = append(errs, fmt.Errorf("dependency cycle: %s", strings.Join(append(chain, name), " -> ")))
+			return
+		case visited:
+			return
+		}
+		state[name] = visiting
+		for _, dep := range deps[name] {
+			if _, ok := deps[dep]; ok {
+				visit(dep, append(chain, name))
+			}
+		}
+		state[name] = visited
+	}
+	for _, slave := range slaves {
+		visit(slave.Name, nil)
+	}
+	return errs
+}
+
+// uploadInputs uploads the outputs of the dependencies of the slave into
+// the inputs directory of its work directory.
+func (b Builder) uploadInputs() error {
+	for _, dep := range b.slave.DependsOn {
+		files := b.inputs[dep]
+		fmt.Fprintf(b.w, "## build -- uploading %d input(s) from [%s]...\n", len(files), dep)
+		b.w.Sync()
+		dir := path.Join(b.slave.Path, InputsDir, dep)
+		err := b.t.Mkdir(b.ctx, dir, b.w)
+		if err != nil {
+			return err
+		}
+		for _, fname := range files {
+			dst := path.Join(dir, filepath.Base(fname))
+			err = b.transfer("upload of "+filepath.Base(fname), func() error {
+				return b.t.CopyTo(b.ctx, fname, dst, b.w)
+			})
+			if err != nil {
+				return fmt.Errorf("could not upload [%s] from [%s] (%v)", fname, dep, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package bldbot
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// DestTemplate maps the outputs retrieved by the builds of a run to their
+// local paths, under output/ (-dest-template), and detects the collisions.
+type DestTemplate struct {
+	tmpl *template.Template
+
+	mu     sync.Mutex
+	claims map[string]string // local path -> slave:output
+}
+
+// ParseDestTemplate parses the Go template text of the local path of each
+// output (see -dest-template.)
+func ParseDestTemplate(text string) (*DestTemplate, error) {
+	tmpl, err := template.New("dest").Option("missingkey=error").Funcs(template.FuncMap{
+		"env": os.Getenv,
+	}).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &DestTemplate{tmpl: tmpl, claims: make(map[string]string)}, nil
+}
+
+// destData is what the -dest-template is executed with.
+type destData struct {
+	Slave Slave
+	Vars  map[string]string // Vars of the slave
+	File  string            // base name of the output
+	Label string            // -run-label
+}
+
+// path returns the local path (under output/) of the output of the slave
+// according to the template, for the run labelled label, claiming it for
+// that output: two outputs mapped to the same path is an error.
+func (d *DestTemplate) path(s *Slave, output, label string) (string, error) {
+	buf := new(bytes.Buffer)
+	err := d.tmpl.Execute(buf, destData{
+		Slave: *s,
+		Vars:  s.Vars,
+		File:  path.Base(output),
+		Label: label,
+	})
+	if err != nil {
+		return "", fmt.Errorf("-dest-template: %v", err)
+	}
+	rel := filepath.Clean(filepath.FromSlash(strings.TrimSpace(buf.String())))
+	if rel == "." || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("-dest-template: invalid path %q for [%s] (want a relative path under output/)", buf.String(), output)
+	}
+	fname := filepath.Join("output", rel)
+
+	owner := s.Name + ":" + path.Base(output)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if other, dup := d.claims[fname]; dup && other != owner {
+		return "", fmt.Errorf("-dest-template: [%s] collides with [%s] at [%s]", owner, other, fname)
+	}
+	d.claims[fname] = owner
+	return fname, nil
+}
+
+// moveToDest moves the output retrieved into the staging directory to its
+// destination fname, creating its directories.
+func moveToDest(staging, output, fname string) error {
+	err := os.MkdirAll(filepath.Dir(fname), 0755)
+	if err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(staging, path.Base(output)), fname)
+}
@@ -0,0 +1,120 @@
+package bldbot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Files written by a detached build into its work directory.
+const (
+	detachedLog  = ".bldbot-build.log" // output of the build-script
+	detachedExit = ".bldbot-exit"      // exit code of the build-script, once it completed
+)
+
+// DetachedBuild is a build launched with -detached, not collected yet.
+type DetachedBuild struct {
+	Run     string    `json:"run"`
+	Slave   string    `json:"slave"`
+	Addr    string    `json:"addr"`
+	Path    string    `json:"path"`
+	Started time.Time `json:"started"`
+}
+
+// detach launches the build command cmd in the background on the slave,
+// its output and exit code written into the work directory, and returns at
+// once.
+func (b Builder) detach(cmd string) BuildReport {
+	fmt.Fprintf(b.w, "## build -- launching build-script in the background...\n")
+	b.w.Sync()
+	dir := b.slave.Path
+	launch := fmt.Sprintf(
+		// (the braces so that only nohup, not the whole list, runs in the
+		// background: the connection ends only once its output is closed.)
+		`cd %[1]s && { nohup "${SHELL:-/bin/sh}" -c %[2]s > %[3]s 2>&1 < /dev/null & }`,
+		ShellQuote(dir),
+		ShellQuote(fmt.Sprintf(
+			"%s; echo $? > %[2]s.tmp && mv %[2]s.tmp %[2]s",
+			cmd, ShellQuote(path.Join(dir, detachedExit)),
+		)),
+		ShellQuote(path.Join(dir, detachedLog)),
+	)
+	err := b.t.Run(b.ctx, launch, nil, b.w, b.w)
+	if err != nil {
+		return BuildReport{
+			Slave: b.slave,
+			Msg:   "could not launch the build-script",
+			Err:   err,
+		}
+	}
+	fmt.Fprintf(b.w, "## build -- detached in [%s]\n", dir)
+	return BuildReport{
+		Slave:    b.slave,
+		Msg:      "detached (collect with the collect command)",
+		Built:    time.Now(),
+		Detached: true,
+	}
+}
+
+// Collect returns the report of the detached build of the slave, and
+// whether it completed (when it did not, nothing is collected.) Its outputs
+// are retrieved as configured by opts.
+func Collect(slave Slave, build DetachedBuild, opts Options) (BuildReport, bool) {
+	err := CheckRemotePath(build.Path)
+	if err != nil {
+		return BuildReport{Slave: slave, Msg: "invalid remote path", Err: err}, true
+	}
+	slave.Path = build.Path
+	t := opts.Transport
+	if t == nil {
+		t = NewTransport(&slave, opts)
+	}
+	out := new(bytes.Buffer)
+	cmd := fmt.Sprintf("if [ -f %[1]s ]; then cat %[1]s; fi", ShellQuote(path.Join(build.Path, detachedExit)))
+	err = t.Run(context.Background(), cmd, nil, out, out)
+	if err != nil {
+		// collected by a later collect.
+		log.Printf("collect: slave [%s] did not respond (%v: %s)\n", slave.Name, err, strings.TrimSpace(out.String()))
+		return BuildReport{}, false
+	}
+	if strings.TrimSpace(out.String()) == "" {
+		opts.progressf(">>> slave [%s]: build of run %s still running\n", slave.Name, build.Run)
+		return BuildReport{}, false
+	}
+	exit, err := strconv.Atoi(strings.TrimSpace(out.String()))
+	if err != nil {
+		exit = -1
+	}
+
+	opts.progressf(">>> collecting the build of slave [%s] (run %s)...\n", slave.Name, build.Run)
+	opts.Transport = t
+	b, report := NewBuilder(slave, 0, opts)
+	if b == nil {
+		return report, true
+	}
+	defer b.Close()
+	defer b.cancel()
+	b.slave.Path = build.Path
+	fmt.Fprintf(b.w, "## build -- collecting detached build of run %s (started %v)\n", build.Run, build.Started)
+	fmt.Fprintf(b.w, "## build -- output of the build-script:\n")
+	b.t.Run(b.ctx, "cat "+ShellQuote(path.Join(build.Path, detachedLog)), nil, b.w, b.w)
+	fmt.Fprintf(b.w, "## build -- build-script exited with code %d\n", exit)
+	b.fetchLogs()
+	if exit != 0 {
+		b.endPhase()
+		return BuildReport{
+			Slave:    b.slave,
+			Msg:      fmt.Sprintf("build failed (exit code %d)", exit),
+			Err:      fmt.Errorf("build-script of run %s exited with code %d", build.Run, exit),
+			ExitCode: exit,
+		}, true
+	}
+	report = b.retrieve(BuildReport{Slave: b.slave, Built: build.Started})
+	b.endPhase()
+	return report, true
+}
@@ -0,0 +1,12 @@
+package bldbot
+
+import (
+	"path/filepath"
+)
+
+// BuildLogName returns the name of the file holding the output of the
+// build-script of that slave in this run, within the -pipeline stage if
+// any (-diff-last.)
+func BuildLogName(name, stage string) string {
+	return StageName(filepath.Join("logs", name+".build.txt"), stage)
+}
@@ -0,0 +1,30 @@
+package bldbot
+
+import (
+	"time"
+)
+
+// Event types.
+const (
+	EventRunStarted     = "run-started"     // the builds of a run are starting
+	EventSlaveStarted   = "slave-started"   // a build started
+	EventPhase          = "phase"           // a build entered a new phase
+	EventSlaveCompleted = "slave-completed" // a build completed (or was not run)
+	EventRunCompleted   = "run-completed"   // all the builds of a run completed
+)
+
+// Event is an orchestration event.
+// With -events-json, each event is written on stdout as a JSON object, one
+// per line. The schema is stable: fields are only ever added.
+type Event struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`             // one of the Event* constants
+	Slave  string    `json:"slave,omitempty"`  // name of the slave (slave and phase events)
+	Phase  string    `json:"phase,omitempty"`  // name of the phase ("phase" events)
+	Status string    `json:"status,omitempty"` // Status* of the build or "ok"/"failed" for the run (completion events)
+	Msg    string    `json:"msg,omitempty"`    // details about the completion (completion events)
+
+	Run    string `json:"run,omitempty"`    // unique id of the run (run events)
+	Label  string `json:"label,omitempty"`  // -run-label of the run, if any (run events)
+	Slaves int    `json:"slaves,omitempty"` // number of slaves of the run ("run-started" events)
+}
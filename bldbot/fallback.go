@@ -0,0 +1,36 @@
+package bldbot
+
+import (
+	"fmt"
+)
+
+// fallback runs the build once more, in a new work directory, with the
+// FallbackScript of the slave instead of build.sh, after the build failed
+// (as reported by failed.)
+func (b Builder) fallback(failed BuildReport) BuildReport {
+	b.slave.script = b.slave.FallbackScript
+	fmt.Fprintf(b.w, "## build -- build failed (%s), falling back to [%s]...\n",
+		failed.Msg, b.slave.LocalCommandFileName(),
+	)
+	b.opts.progressf(">>> slave [%s]: build failed, falling back to [%s]...\n",
+		b.slave.Name, b.slave.LocalCommandFileName(),
+	)
+
+	b.cleanup()
+	path, err := b.slave.WorkDir(b.opts.PersistentWorkdir)
+	if err != nil {
+		fmt.Fprintf(b.w, "## build -- could not create a new work directory (%v)\n", err)
+		return failed
+	}
+	b.slave.Path = path
+
+	report := b.attempt()
+	report.Attempts = failed.Attempts + 1
+	report.Fallback = true
+	if report.Err != nil && b.ctx.Err() != nil {
+		fmt.Fprintf(b.w, "## build -- cancelled\n")
+		report.Msg = "cancelled"
+		report.Cancelled = true
+	}
+	return report
+}
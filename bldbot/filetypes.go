@@ -0,0 +1,163 @@
+package bldbot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// fileType describes how a type of output is recognized: by the magic bytes
+// found at offset, and for the compressed ones by decompressing them whole
+// (which catches the truncated ones.)
+type fileType struct {
+	offset int64
+	magic  []byte
+	check  func(io.Reader) error
+}
+
+// fileTypes are the types of output -verify-types knows of, by name.
+var fileTypes = map[string]fileType{
+	"gzip":  {magic: []byte{0x1f, 0x8b}, check: checkGzip},
+	"zip":   {magic: []byte("PK\x03\x04")},
+	"bzip2": {magic: []byte("BZh")},
+	"xz":    {magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	"zstd":  {magic: []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	"tar":   {offset: 257, magic: []byte("ustar")},
+	"ar":    {magic: []byte("!<arch>\n")},
+	"rpm":   {magic: []byte{0xed, 0xab, 0xee, 0xdb}},
+	"elf":   {magic: []byte("\x7fELF")},
+	"png":   {magic: []byte("\x89PNG\r\n\x1a\n")},
+	"pdf":   {magic: []byte("%PDF-")},
+}
+
+// typeExts are the types expected of the outputs, by extension (longest
+// extensions first.)
+var typeExts = []struct {
+	ext, name string
+}{
+	{".tar.gz", "gzip"},
+	{".tar.bz2", "bzip2"},
+	{".tar.xz", "xz"},
+	{".tar.zst", "zstd"},
+	{".tgz", "gzip"},
+	{".gz", "gzip"},
+	{".bz2", "bzip2"},
+	{".xz", "xz"},
+	{".zst", "zstd"},
+	{".tar", "tar"},
+	{".zip", "zip"},
+	{".jar", "zip"},
+	{".whl", "zip"},
+	{".deb", "ar"},
+	{".rpm", "rpm"},
+	{".png", "png"},
+	{".pdf", "pdf"},
+}
+
+// expectedType returns the name of the type expected of the output fname:
+// the first OutputTypes pattern (by sorted pattern) matching its base name,
+// or else from its extension. It returns "" when no type is expected.
+func (s *Slave) expectedType(fname string) string {
+	base := filepath.Base(fname)
+	patterns := make([]string, 0, len(s.OutputTypes))
+	for pattern := range s.OutputTypes {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return s.OutputTypes[pattern]
+		}
+	}
+	for _, t := range typeExts {
+		if strings.HasSuffix(base, t.ext) {
+			return t.name
+		}
+	}
+	return ""
+}
+
+// checkType returns an error describing why the file fname is not of the
+// type name.
+func checkType(fname, name string) error {
+	t, ok := fileTypes[name]
+	if !ok {
+		return fmt.Errorf("unknown type %q", name)
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := make([]byte, len(t.magic))
+	_, err = f.ReadAt(buf, t.offset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if !bytes.Equal(buf, t.magic) {
+		start := head(f, 8)
+		if len(start) <= 0 {
+			return fmt.Errorf("not %s (empty)", name)
+		}
+		return fmt.Errorf("not %s (starts with % x)", name, start)
+	}
+	if t.check != nil {
+		err = t.check(f)
+		if err != nil {
+			return fmt.Errorf("corrupt %s (%v)", name, err)
+		}
+	}
+	return nil
+}
+
+// head returns (at most) the first n bytes of f.
+func head(f *os.File, n int) []byte {
+	buf := make([]byte, n)
+	m, _ := f.ReadAt(buf, 0)
+	return buf[:m]
+}
+
+// checkGzip decompresses the gzip stream r whole, which verifies its CRC
+// and length.
+func checkGzip(r io.Reader) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(ioutil.Discard, zr)
+	if err != nil {
+		return err
+	}
+	return zr.Close()
+}
+
+// verifyTypes checks that the retrieved outputs of the build are of their
+// expected type. Any mismatch fails the build.
+func (b Builder) verifyTypes(report *BuildReport) {
+	bad := make([]string, 0)
+	for _, fname := range report.Outputs {
+		name := b.slave.expectedType(fname)
+		if name == "" {
+			fmt.Fprintf(b.w, "## build -- [%s]: no expected type, not verified\n", fname)
+			continue
+		}
+		err := checkType(fname, name)
+		if err != nil {
+			fmt.Fprintf(b.w, "## build -- [%s]: %v\n", fname, err)
+			bad = append(bad, fmt.Sprintf("%s: %v", filepath.Base(fname), err))
+			continue
+		}
+		fmt.Fprintf(b.w, "## build -- [%s]: %s\n", fname, name)
+	}
+	if len(bad) > 0 {
+		report.Msg = fmt.Sprintf("%d output(s) not of the expected type", len(bad))
+		report.Err = fmt.Errorf("outputs of unexpected type: %s", strings.Join(bad, "; "))
+	}
+}
@@ -0,0 +1,74 @@
+package bldbot
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ScriptFile returns the name of the local file holding the build-script of
+// that slave, the git revision it was resolved from (if ScriptRef is set)
+// and the function removing that file once uploaded.
+//
+// With a ScriptRef, the build-script (<Name>/build.sh, relative to the root
+// of ScriptRepo) is extracted from that revision of the repository, whatever
+// the state of its worktree.
+func (s *Slave) ScriptFile() (string, string, func(), error) {
+	if s.command != "" {
+		fname, remove, err := s.commandScript()
+		return fname, "", remove, err
+	}
+	if s.ScriptRef == "" {
+		return s.LocalCommandFileName(), "", func() {}, nil
+	}
+	repo := s.ScriptRepo
+	if repo == "" {
+		repo = "."
+	}
+
+	git := func(args ...string) ([]byte, error) {
+		stderr := new(bytes.Buffer)
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Stderr = stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("git %s failed (%v: %s)", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+		}
+		return out, nil
+	}
+
+	rev, err := git("rev-parse", "--verify", s.ScriptRef+"^{commit}")
+	if err != nil {
+		return "", "", nil, err
+	}
+	commit := strings.TrimSpace(string(rev))
+	name := filepath.ToSlash(s.LocalCommandFileName())
+	script, err := git("show", commit+":"+name)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	f, err := ioutil.TempFile("", "go-bldbot-script-")
+	if err != nil {
+		return "", "", nil, err
+	}
+	remove := func() { os.Remove(f.Name()) }
+	_, err = f.Write(script)
+	if err == nil {
+		err = f.Chmod(0755)
+	}
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		remove()
+		return "", "", nil, err
+	}
+	return f.Name(), commit, remove, nil
+}
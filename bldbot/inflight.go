@@ -0,0 +1,108 @@
+package bldbot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ByteBudget tracks the bytes in use out of a budget shared by concurrent
+// users.
+type ByteBudget struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	max  int64
+	used int64
+}
+
+// NewByteBudget returns a budget of max bytes.
+func NewByteBudget(max int64) *ByteBudget {
+	bb := &ByteBudget{max: max}
+	bb.cond = sync.NewCond(&bb.mu)
+	return bb
+}
+
+// acquire blocks until n bytes of the budget are available, and returns
+// the function giving them back, or until ctx is done, returning its error.
+// A request larger than the whole budget waits for all the others to
+// complete.
+func (bb *ByteBudget) acquire(ctx context.Context, n int64) (func(), error) {
+	if n > bb.max {
+		n = bb.max
+	}
+	acquired := make(chan struct{})
+	defer close(acquired)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// wake the waiters, for them to notice.
+			bb.mu.Lock()
+			bb.cond.Broadcast()
+			bb.mu.Unlock()
+		case <-acquired:
+		}
+	}()
+
+	bb.mu.Lock()
+	for bb.used > 0 && bb.used+n > bb.max {
+		if err := ctx.Err(); err != nil {
+			bb.mu.Unlock()
+			return nil, err
+		}
+		bb.cond.Wait()
+	}
+	bb.used += n
+	bb.mu.Unlock()
+	return func() {
+		bb.mu.Lock()
+		bb.used -= n
+		bb.mu.Unlock()
+		bb.cond.Broadcast()
+	}, nil
+}
+
+// reserveInflight waits until the outputs of the build (whose sizes are
+// read on the slave) may be retrieved within the Inflight budget, and
+// returns the function releasing their share of the budget; or the error
+// of the build being cancelled while waiting.
+func (b Builder) reserveInflight(outputs []string) (func(), error) {
+	args := make([]string, 0, len(outputs))
+	for _, output := range outputs {
+		args = append(args, ShellQuote(output))
+	}
+	cmd := fmt.Sprintf(`for f in %s; do wc -c < "$f"; done`, strings.Join(args, " "))
+	out := new(bytes.Buffer)
+	err := b.t.Run(b.ctx, cmd, nil, out, b.w)
+	if err != nil {
+		return nil, err
+	}
+	total := int64(0)
+	for _, line := range strings.Fields(out.String()) {
+		n, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output size %q", line)
+		}
+		total += n
+	}
+
+	fmt.Fprintf(b.w, "## build -- waiting for %s of retrieval budget...\n", HumanSize(total))
+	b.w.Sync()
+	return b.opts.Inflight.acquire(b.ctx, total)
+}
+
+// HumanSize formats a size in bytes with a binary unit prefix.
+func HumanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
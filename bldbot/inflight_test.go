@@ -0,0 +1,46 @@
+package bldbot
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestByteBudgetCancel(t *testing.T) {
+	bb := NewByteBudget(100)
+	release, err := bb.acquire(context.Background(), 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := bb.acquire(ctx, 50)
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		t.Fatalf("acquire over the budget returned %v, want it to block", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Errorf("cancelled acquire = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("cancelled acquire still blocked")
+	}
+
+	// the cancelled request took nothing from the budget.
+	release()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	release, err = bb.acquire(ctx, 100)
+	if err != nil {
+		t.Fatalf("acquire of the whole budget = %v, want nil", err)
+	}
+	release()
+}
@@ -0,0 +1,185 @@
+package bldbot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// logStamp is the layout of the timestamps of the lines (-timestamp-logs.)
+const logStamp = "2006-01-02T15:04:05.000Z07:00 "
+
+// logFile is the logfile of a builder.
+// When its size is capped (-max-log-size), the logfile keeps the first and
+// the last max/2 bytes written to it: the head is written as it comes, the
+// tail is held in memory (at most -log-buffer-bytes, if set) and written
+// (after an elision marker) on Close.
+type logFile struct {
+	mu     sync.Mutex
+	f      *os.File
+	max    int64  // maximum size (0: unlimited)
+	n      int64  // number of bytes in f
+	tail   []byte // last bytes written, once the head is full
+	elided int64  // number of bytes dropped from the tail
+
+	// tee, if any, gets a copy of all the output (e.g. -syslog) whatever
+	// the cap. Its errors are ignored.
+	tee io.Writer
+
+	// red, if any, redacts the secrets of the build (SecretEnv) from all
+	// the output, tee included.
+	red *redactor
+
+	// stamp tells each line is prefixed with the time it started to be
+	// written (-timestamp-logs), midline that the last line written is not
+	// complete yet.
+	stamp   bool
+	midline bool
+
+	closed bool
+
+	bufmax int // cap on the tail held in memory (-log-buffer-bytes, 0: none)
+}
+
+// openLogFile opens (for appending) or creates the logfile fname, whose
+// size is capped to max bytes (0: unlimited.)
+func openLogFile(fname string, flags int, max int64) (*logFile, error) {
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l := &logFile{f: f, max: max}
+	if fi, err := f.Stat(); err == nil {
+		l.n = fi.Size()
+	}
+	return l, nil
+}
+
+// openLog opens (see openLogFile) the logfile fname of a build configured
+// by opts.
+func (opts Options) openLog(fname string, flags int) (*logFile, error) {
+	l, err := openLogFile(fname, flags, opts.MaxLogSize)
+	if err != nil {
+		return nil, err
+	}
+	l.stamp = opts.TimestampLogs
+	l.bufmax = opts.LogBufferBytes
+	return l, nil
+}
+
+func (l *logFile) Write(data []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := len(data)
+	if l.red != nil {
+		data = l.red.filter(data)
+	}
+	_, err := l.write(data)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (l *logFile) write(data []byte) (int, error) {
+	if l.tee != nil {
+		l.tee.Write(data)
+	}
+	if l.stamp {
+		data = l.timestamp(data)
+	}
+	if l.max <= 0 {
+		return l.f.Write(data)
+	}
+
+	n := len(data)
+	if head := l.max/2 - l.n; head > 0 {
+		if int64(len(data)) < head {
+			head = int64(len(data))
+		}
+		nn, err := l.f.Write(data[:head])
+		l.n += int64(nn)
+		if err != nil {
+			return nn, err
+		}
+		data = data[head:]
+	}
+
+	size := bufferCap(int(l.max-l.max/2), l.bufmax)
+	l.tail = append(l.tail, data...)
+	if drop := len(l.tail) - size; drop > 0 {
+		l.elided += int64(drop)
+		l.tail = l.tail[drop:]
+		if cap(l.tail) > 2*size+4096 {
+			// do not hold onto an ever growing buffer.
+			l.tail = append(make([]byte, 0, size), l.tail...)
+		}
+	}
+	return n, nil
+}
+
+// timestamp returns data with the time prefixed to each line started in it.
+// Partial lines are not held back: a line is stamped with the time its first
+// bytes were written.
+func (l *logFile) timestamp(data []byte) []byte {
+	if len(data) <= 0 {
+		return data
+	}
+	stamp := []byte(time.Now().Format(logStamp))
+	out := make([]byte, 0, len(data)+len(stamp)*(bytes.Count(data, []byte{'\n'})+1))
+	for len(data) > 0 {
+		if !l.midline {
+			out = append(out, stamp...)
+		}
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			out = append(out, data...)
+			l.midline = true
+			break
+		}
+		out = append(out, data[:i+1]...)
+		data = data[i+1:]
+		l.midline = false
+	}
+	return out
+}
+
+func (l *logFile) Sync() error {
+	return l.f.Sync()
+}
+
+func (l *logFile) Name() string {
+	if l.f == nil {
+		return ""
+	}
+	return l.f.Name()
+}
+
+// Close writes out the held tail of a capped logfile and closes it.
+// Closing it again does nothing.
+func (l *logFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	if l.red != nil {
+		l.write(l.red.flush())
+	}
+	if f, ok := l.tee.(interface{ Flush() }); ok {
+		f.Flush()
+	}
+	if l.elided > 0 {
+		fmt.Fprintf(l.f, "\n[... %d bytes elided ...]\n", l.elided)
+		l.elided = 0
+	}
+	if len(l.tail) > 0 {
+		l.f.Write(l.tail)
+		l.tail = nil
+	}
+	return l.f.Close()
+}
@@ -0,0 +1,109 @@
+package bldbot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogFileRedaction(t *testing.T) {
+	secrets := map[string]string{"TOKEN": "s3cr3t-t0k3n", "PASS": "hunter2"}
+	filler := strings.Repeat("x", 100)
+	for _, tc := range []struct {
+		name   string
+		writes []string
+		max    int64
+		want   int // number of redacted secrets in the logfile (-1: any)
+	}{
+		{
+			name:   "whole",
+			writes: []string{"token=s3cr3t-t0k3n pass=hunter2\n"},
+			want:   2,
+		},
+		{
+			name:   "split across writes",
+			writes: []string{"token=s3c", "r3t-t", "0k3n pass=hu", "nter2\n"},
+			want:   2,
+		},
+		{
+			name:   "byte by byte",
+			writes: strings.Split("token=s3cr3t-t0k3n\n", ""),
+			want:   1,
+		},
+		{
+			name:   "at the end of the output",
+			writes: []string{"pass=hunt", "er2"},
+			want:   1,
+		},
+		{
+			name:   "prefix only",
+			writes: []string{"pass=hunt", "ing\n"},
+			want:   0,
+		},
+		{
+			name:   "across the head of a capped logfile",
+			writes: []string{filler[:45], "s3cr3t", "-t0k3n", filler},
+			max:    100,
+			want:   -1,
+		},
+		{
+			name:   "in the tail of a capped logfile",
+			writes: []string{filler, filler, "pass=hun", "ter2", filler[:10]},
+			max:    100,
+			want:   1,
+		},
+		{
+			name:   "in the elided middle of a capped logfile",
+			writes: []string{filler, "token=s3cr3t-t0k3n", filler, filler},
+			max:    100,
+			want:   0,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "go-bldbot-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			fname := filepath.Join(dir, "log.txt")
+			l, err := openLogFile(fname, os.O_TRUNC, tc.max)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.red = newRedactor(secrets)
+			tee := new(strings.Builder)
+			l.tee = tee
+			for _, data := range tc.writes {
+				n, err := l.Write([]byte(data))
+				if err != nil || n != len(data) {
+					t.Fatalf("Write(%q) = %d, %v", data, n, err)
+				}
+			}
+			err = l.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			buf, err := ioutil.ReadFile(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, out := range []struct{ what, s string }{{"logfile", string(buf)}, {"tee", tee.String()}} {
+				for _, secret := range secrets {
+					if strings.Contains(out.s, secret) {
+						t.Errorf("%s %q holds secret %q", out.what, out.s, secret)
+					}
+				}
+			}
+			if got := strings.Count(string(buf), redacted); tc.want >= 0 && got != tc.want {
+				t.Errorf("logfile %q holds %d redacted secret(s), want %d", buf, got, tc.want)
+			}
+			if tc.max > 0 && int64(len(buf)) > tc.max+int64(len("\n[... 1000 bytes elided ...]\n")) {
+				t.Errorf("logfile of %d bytes, above -max-log-size %d", len(buf), tc.max)
+			}
+		})
+	}
+}
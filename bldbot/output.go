@@ -0,0 +1,252 @@
+package bldbot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bufferCap returns the size n (0: unbounded) of a buffer of build output,
+// capped to max (-log-buffer-bytes, 0: no cap.)
+func bufferCap(n, max int) int {
+	if max > 0 && (n <= 0 || n > max) {
+		return max
+	}
+	return n
+}
+
+// patternScanner is an io.Writer scanning the lines written to it for the
+// first one matching any of a list of patterns.
+type patternScanner struct {
+	mu    sync.Mutex
+	res   []*regexp.Regexp
+	skip  *regexp.Regexp // lines not scanned, if any
+	buf   []byte
+	max   int    // cap on buf (-log-buffer-bytes, 0: none)
+	match string // first matching line
+}
+
+func newPatternScanner(res []*regexp.Regexp, max int) *patternScanner {
+	return &patternScanner{res: res, max: max}
+}
+
+// timeOutputRe matches the lines of the report of the (remote) time command.
+var timeOutputRe = regexp.MustCompile(`^(real|user|sys)[ \t]+[0-9][0-9hms.:,]*$`)
+
+// newStderrScanner returns a scanner for the first line written on the
+// stderr of a build-script (-fail-on-stderr), leaving out the report of the
+// time command unless the build-script runs without it (-no-time.)
+func newStderrScanner(noTime bool, max int) *patternScanner {
+	p := newPatternScanner([]*regexp.Regexp{regexp.MustCompile(`\S`)}, max)
+	if !noTime {
+		p.skip = timeOutputRe
+	}
+	return p
+}
+
+func (p *patternScanner) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buf = append(p.buf, data...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		p.scan(p.buf[:i])
+		p.buf = p.buf[i+1:]
+	}
+	if max := p.max; max > 0 {
+		// scanned in pieces (-log-buffer-bytes.)
+		for len(p.buf) > max {
+			p.scan(p.buf[:max])
+			p.buf = p.buf[max:]
+		}
+	}
+	return len(data), nil
+}
+
+func (p *patternScanner) scan(line []byte) {
+	if p.match != "" {
+		return
+	}
+	if p.skip != nil && p.skip.Match(line) {
+		return
+	}
+	for _, re := range p.res {
+		if re.Match(line) {
+			p.match = string(line)
+			return
+		}
+	}
+}
+
+// Match returns the first line which matched, flushing any partial last
+// line. It returns false if none matched.
+func (p *patternScanner) Match() (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.buf) > 0 {
+		p.scan(p.buf)
+		p.buf = nil
+	}
+	return p.match, p.match != ""
+}
+
+var rsyncOnce struct {
+	sync.Once
+	ok bool
+}
+
+// haveRsync returns whether rsync is available locally, logging (once) when
+// it is not.
+func haveRsync() bool {
+	rsyncOnce.Do(func() {
+		_, err := exec.LookPath("rsync")
+		rsyncOnce.ok = err == nil
+		if !rsyncOnce.ok {
+			log.Printf("rsync not found, no progress report for outputs retrieval\n")
+		}
+	})
+	return rsyncOnce.ok
+}
+
+// progressWriter is an io.Writer receiving the (carriage-return separated)
+// progress updates of rsync --progress.
+// Each update is written as a line to the logfile, and progress updates are
+// echoed on the console (at most once per second.)
+type progressWriter struct {
+	w         io.Writer
+	name      string
+	buf       []byte
+	max       int // cap on buf (-log-buffer-bytes, 0: none)
+	progressf func(format string, args ...interface{})
+	last      time.Time
+}
+
+// newProgressWriter returns a progressWriter for the slave name, writing to
+// w and echoing the updates with the Progressf of opts.
+func newProgressWriter(w io.Writer, name string, opts Options) *progressWriter {
+	return &progressWriter{w: w, name: name, max: opts.LogBufferBytes, progressf: opts.Progressf}
+}
+
+func (p *progressWriter) Write(data []byte) (int, error) {
+	p.buf = append(p.buf, data...)
+	for {
+		i := bytes.IndexAny(p.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		p.line(string(p.buf[:i]), p.buf[i] == '\n')
+		p.buf = p.buf[i+1:]
+	}
+	if p.max > 0 && len(p.buf) > p.max {
+		p.line(string(p.buf), false)
+		p.buf = nil
+	}
+	return len(data), nil
+}
+
+func (p *progressWriter) line(line string, done bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	fmt.Fprintf(p.w, "%s\n", line)
+	if !strings.Contains(line, "%") {
+		return
+	}
+	if p.progressf != nil && (done || time.Since(p.last) >= time.Second) {
+		p.last = time.Now()
+		p.progressf(" %s: %s\n", p.name, line)
+	}
+}
+
+// Flush writes out any partial last line.
+func (p *progressWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.line(string(p.buf), true)
+		p.buf = nil
+	}
+}
+
+// maxTailLine is the maximum length of a line kept by a tailBuffer.
+const maxTailLine = 1024
+
+// tailBuffer is an io.Writer keeping the last n lines written to it, in a
+// ring buffer so memory stays bounded whatever the size of the output: at
+// most -log-buffer-bytes, if set, the oldest lines dropped to stay within.
+type tailBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	first int // index of the oldest line
+	count int // number of lines held
+	size  int // number of bytes held, in lines
+	max   int // maximum size (0: unbounded)
+	buf   []byte
+}
+
+func newTailBuffer(n, max int) *tailBuffer {
+	return &tailBuffer{lines: make([]string, n), max: bufferCap(0, max)}
+}
+
+func (t *tailBuffer) Write(data []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(data)
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			t.append(data)
+			break
+		}
+		t.append(data[:i])
+		t.push()
+		data = data[i+1:]
+	}
+	return n, nil
+}
+
+func (t *tailBuffer) append(data []byte) {
+	max := maxTailLine
+	if t.max > 0 && t.max < max {
+		max = t.max
+	}
+	if n := max - len(t.buf); len(data) > n {
+		data = data[:n]
+	}
+	t.buf = append(t.buf, data...)
+}
+
+func (t *tailBuffer) push() {
+	for t.count > 0 && (t.count == len(t.lines) || (t.max > 0 && t.size+len(t.buf) > t.max)) {
+		t.size -= len(t.lines[t.first])
+		t.lines[t.first] = ""
+		t.first = (t.first + 1) % len(t.lines)
+		t.count--
+	}
+	t.lines[(t.first+t.count)%len(t.lines)] = string(t.buf)
+	t.size += len(t.buf)
+	t.count++
+	t.buf = t.buf[:0]
+}
+
+// Lines returns the last lines written, oldest first.
+func (t *tailBuffer) Lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.buf) > 0 {
+		t.push()
+	}
+	lines := make([]string, 0, t.count)
+	for i := 0; i < t.count; i++ {
+		lines = append(lines, t.lines[(t.first+i)%len(t.lines)])
+	}
+	return lines
+}
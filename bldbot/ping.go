@@ -0,0 +1,42 @@
+package bldbot
+
+import (
+	"fmt"
+	"time"
+)
+
+// PingResult is the outcome of a ping of a slave.
+type PingResult struct {
+	Err     error
+	Latency time.Duration // time the slave took to answer (when it did)
+}
+
+// TimedPing pings the slave (see Ping) and measures the time it took to
+// answer.
+func (s *Slave) TimedPing(opts Options) PingResult {
+	start := time.Now()
+	err := s.Ping(opts)
+	return PingResult{Err: err, Latency: time.Since(start)}
+}
+
+// checkPing logs the ping latency of the slave, warns (in its logfile, see
+// also summarize) when it is slow to respond and returns an error when it is
+// above -ping-latency-threshold.
+func (b Builder) checkPing() error {
+	if b.ping <= 0 {
+		return nil
+	}
+	latency := b.ping.Round(time.Millisecond)
+	fmt.Fprintf(b.w, "## build -- ping latency %v\n", latency)
+	if max := b.opts.PingLatencyThreshold; max > 0 && b.ping > max {
+		fmt.Fprintf(b.w, "## build -- ping latency above -ping-latency-threshold %v\n", max)
+		return fmt.Errorf(
+			"slave [%s] took %v to respond (-ping-latency-threshold %v)",
+			b.slave.Name, latency, max,
+		)
+	}
+	if warn := b.opts.PingLatencyWarn; warn > 0 && b.ping > warn {
+		fmt.Fprintf(b.w, "## build -- slow to respond (above %v)\n", warn)
+	}
+	return nil
+}
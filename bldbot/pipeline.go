@@ -0,0 +1,42 @@
+package bldbot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// StageName returns the name of the run-wide file (or directory) fname
+// within the stage: suffixed with its name, if any (e.g. logs/s1-build.txt.)
+func StageName(fname, stage string) string {
+	if stage == "" {
+		return fname
+	}
+	ext := filepath.Ext(fname)
+	return strings.TrimSuffix(fname, ext) + "-" + stage + ext
+}
+
+// commandScript writes the Command of the stage as the build-script of the
+// slave into a temporary file, and returns its name and a function
+// removing it.
+func (s *Slave) commandScript() (string, func(), error) {
+	f, err := ioutil.TempFile("", "go-bldbot-command-")
+	if err != nil {
+		return "", nil, err
+	}
+	remove := func() { os.Remove(f.Name()) }
+	_, err = fmt.Fprintf(f, "#!/bin/sh\ncd \"$1\" || exit 1\n%s\n", s.command)
+	if err == nil {
+		err = f.Chmod(0755)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		remove()
+		return "", nil, err
+	}
+	return f.Name(), remove, nil
+}
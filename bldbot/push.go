@@ -0,0 +1,74 @@
+package bldbot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// pushDigestRe matches the digest reported by docker push.
+var pushDigestRe = regexp.MustCompile(`digest: (sha256:[0-9a-f]{64})`)
+
+// PushRef returns the reference the image is pushed as: the image, under
+// the Registry of the slave if any.
+func (s *Slave) PushRef(image string) string {
+	reg := strings.TrimSuffix(s.Registry, "/")
+	if reg == "" || strings.HasPrefix(image, reg+"/") {
+		return image
+	}
+	return reg + "/" + image
+}
+
+// pushImages pushes the PushImage images of the slave, built on the slave,
+// with the docker of the slave (logging in to the Registry first, with the
+// credentials read from stdin), and returns the pushed references with their
+// digests (<ref>@sha256:...)
+func (b Builder) pushImages() ([]string, error) {
+	login := ""
+	var creds []string
+	if b.slave.RegistryUserEnv != "" {
+		creds = []string{b.slave.RegistryUserEnv, b.slave.RegistryPasswordEnv}
+		reg := strings.SplitN(b.slave.Registry, "/", 2)[0] // (default: Docker Hub)
+		if reg != "" {
+			reg = " " + ShellQuote(reg)
+		}
+		login = fmt.Sprintf(
+			`printf '%%s\n' "$%s" | docker login --username "$%s" --password-stdin%s && `,
+			b.slave.RegistryPasswordEnv, b.slave.RegistryUserEnv, reg,
+		)
+	}
+
+	pushed := make([]string, 0, len(b.slave.PushImage))
+	for i, image := range b.slave.PushImage {
+		ref := b.slave.PushRef(image)
+		fmt.Fprintf(b.w, "## build -- pushing image [%s]...\n", ref)
+		b.w.Sync()
+		cmd := ""
+		var names []string
+		if i == 0 {
+			// docker keeps the login for the next pushes.
+			cmd = login
+			names = creds
+		}
+		if ref != image {
+			cmd += fmt.Sprintf("docker tag %s %s && ", ShellQuote(image), ShellQuote(ref))
+		}
+		cmd += fmt.Sprintf("docker push %s", ShellQuote(ref))
+		cmd, stdin := secretsPrelude(names, b.secrets, cmd)
+
+		out := new(bytes.Buffer)
+		err := b.t.Run(b.ctx, cmd, stdin, io.MultiWriter(b.w, out), b.w)
+		if err != nil {
+			return pushed, fmt.Errorf("could not push image [%s] (exit code %d)", ref, ExitCode(err))
+		}
+		m := pushDigestRe.FindAllStringSubmatch(out.String(), -1)
+		if len(m) <= 0 {
+			pushed = append(pushed, ref)
+			continue
+		}
+		pushed = append(pushed, ref+"@"+m[len(m)-1][1])
+	}
+	return pushed, nil
+}
@@ -0,0 +1,47 @@
+package bldbot
+
+// Status values of a build report.
+const (
+	StatusOK          = "ok"
+	StatusFailed      = "failed"
+	StatusCancelled   = "cancelled"
+	StatusUnreachable = "unreachable"
+	StatusAborted     = "aborted"
+	StatusSkipped     = "skipped"
+	StatusDetached    = "detached"
+)
+
+// Status returns the status of the build.
+func (r BuildReport) Status() string {
+	switch {
+	case r.Unreachable:
+		return StatusUnreachable
+	case r.Aborted:
+		return StatusAborted
+	case r.Cancelled:
+		return StatusCancelled
+	case r.Skipped:
+		return StatusSkipped
+	case r.Detached:
+		return StatusDetached
+	case r.Err != nil:
+		return StatusFailed
+	}
+	return StatusOK
+}
+
+// Failed returns whether the build failed (or was not run for a reason
+// other than its Condition, or is still running detached.)
+func (r BuildReport) Failed() bool {
+	switch r.Status() {
+	case StatusOK, StatusSkipped, StatusDetached:
+		return false
+	}
+	return true
+}
+
+// InOutputDir returns whether the outputs of the build were retrieved into
+// the (local) output directory, neither sent to a collector nor archived.
+func (r BuildReport) InOutputDir() bool {
+	return !r.Collected && !r.Archived
+}
@@ -0,0 +1,57 @@
+package bldbot
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Reschedule returns whether the build of the report (its n-th
+// rescheduling) is to be run again later, as its slave does not respond
+// any more, and prepares the builder for it.
+func (b *Builder) Reschedule(report BuildReport, n int) bool {
+	if !b.opts.Reschedule || n >= b.opts.MaxReschedules {
+		return false
+	}
+	if !report.Failed() || report.Cancelled || b.ctx.Err() != nil {
+		return false
+	}
+	err := b.slave.Ping(b.opts)
+	if err == nil {
+		// a genuine failure.
+		return false
+	}
+
+	// run closed the logfile.
+	f, err := b.opts.openLog(b.w.Name(), os.O_APPEND)
+	if err != nil {
+		return false
+	}
+	f.tee = b.w.tee
+	f.red = newRedactor(b.secrets)
+	b.w = f
+	if !b.opts.PersistentWorkdir {
+		path, err := b.slave.WorkDir(b.opts.PersistentWorkdir)
+		if err != nil {
+			b.w.Close()
+			return false
+		}
+		b.slave.Path = path
+	}
+	fmt.Fprintf(b.w, "## build -- slave unreachable, rescheduled in %v (%d/%d)\n",
+		b.opts.RescheduleDelay, n+1, b.opts.MaxReschedules,
+	)
+	b.opts.progressf(">>> slave [%s] unreachable, rescheduled in %v (%d/%d)\n",
+		b.slave.Name, b.opts.RescheduleDelay, n+1, b.opts.MaxReschedules,
+	)
+	return true
+}
+
+// Wait waits for the RescheduleDelay of a rescheduled build, or its
+// cancellation.
+func (b *Builder) Wait() {
+	select {
+	case <-time.After(b.opts.RescheduleDelay):
+	case <-b.ctx.Done():
+	}
+}
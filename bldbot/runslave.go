@@ -0,0 +1,395 @@
+package bldbot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// Options configures the build of a slave (see RunSlave.) The go-bldbot
+// command fills them from its flags; the zero value builds the slave once,
+// without a timeout nor any check of its output.
+type Options struct {
+	// Timeout, if set, overrides the Timeout of the slave. DefaultTimeout
+	// (-timeout) is that of the slaves without a Timeout, and MaxTimeout
+	// (-max-timeout) the ceiling on both (0: none.) MaxBuildDuration is
+	// that of the slaves without a MaxBuildDuration (-max-build-duration.)
+	Timeout          time.Duration
+	DefaultTimeout   time.Duration
+	MaxTimeout       time.Duration
+	MaxBuildDuration time.Duration
+
+	// Retries is the number of times a failed build is run again
+	// (-build-retries), TransferRetries that a failed copy to or from the
+	// slave is (-transfer-retries.)
+	Retries         int
+	TransferRetries int
+
+	// Transport, if set, is how the slave is reached instead of ssh/scp (or
+	// the local shell.) Otherwise, TransportMode is how files are copied
+	// (-transport: scp, tar, auto or rsync; default: scp), SSHOptions are
+	// the options of all the ssh, scp and rsync commands (-ssh-compression,
+	// -cipher, -bind-address), Connections, if set, limits the rate at which
+	// they are run (-connect-rate) and CommandHook, if set, rewrites them
+	// (-command-hook.)
+	Transport     Transport
+	TransportMode string
+	SSHOptions    []string
+	Connections   *TokenBucket
+	CommandHook   CommandHook
+
+	// How the build-script is run: from the same work directory across
+	// builds with PersistentWorkdir (-persistent-workdir), whose outputs
+	// are only retrieved again with RetrieveOnly (-retrieve-only), or in
+	// the background with Detached (-detached.) It is uploaded gzipped with
+	// CompressUpload (-compress-upload), delayed by up to Stagger
+	// (-stagger), and runs under nice and ionice Nice and IONice (-nice,
+	// -ionice, for the slaves without their own), under time unless NoTime
+	// (-no-time) and in a login shell with LoginShell (-login-shell.)
+	PersistentWorkdir bool
+	RetrieveOnly      bool
+	Detached          bool
+	CompressUpload    bool
+	Stagger           time.Duration
+	Nice              int
+	IONice            int
+	NoTime            bool
+	LoginShell        bool
+
+	// Log, if set, gets a copy of all the output written to the logfile of
+	// the build (secrets redacted), and is left open. MaxLogSize caps the
+	// size of the logfile (-max-log-size, 0: unlimited), SplitLogs splits
+	// it per phase (-split-logs), TimestampLogs prefixes its lines with
+	// their time (-timestamp-logs) and Syslog, if set, is the server the
+	// output is also sent to (-syslog.) DiffLast keeps the output of the
+	// build-script for -diff-last. LogBufferBytes caps each buffer of
+	// output held in memory (-log-buffer-bytes, 0: no cap.)
+	Log            io.Writer
+	MaxLogSize     int64
+	SplitLogs      bool
+	TimestampLogs  bool
+	Syslog         string
+	DiffLast       bool
+	LogBufferBytes int
+
+	// Progressf and Summaryf, if set, print (as fmt.Printf) the progress
+	// messages and the lines of the summary of the run about the build.
+	// Events, if set, gets the events of the build (slave-started, phase)
+	// and BuildOutput, if set, returns a writer getting a copy of the output
+	// of the build-script of a slave (e.g. the -tui dashboard.)
+	Progressf   func(format string, args ...interface{})
+	Summaryf    func(format string, args ...interface{})
+	Events      func(Event)
+	BuildOutput func(slave string) io.Writer
+
+	// Stage, if set, is the -pipeline stage the build is part of, whose
+	// name suffixes those of the logfiles of the build. RunLabel is the
+	// -run-label of the run.
+	Stage    string
+	RunLabel string
+
+	// The checks of the slave: SysInfo captures its system info
+	// (-sysinfo), with SysInfoCommands overriding the default commands
+	// (-sysinfo-command), PingLatencyWarn and PingLatencyThreshold are the
+	// ping latencies above which it is reported as slow and its build fails
+	// (-ping-latency-warn, -ping-latency-threshold), ClockSkew the clock
+	// skew warned about above, or failing the build with ClockSkewFail
+	// (-clock-skew, -clock-skew-fail; 0: none.)
+	SysInfo              bool
+	SysInfoCommands      map[string]string
+	PingLatencyWarn      time.Duration
+	PingLatencyThreshold time.Duration
+	ClockSkew            time.Duration
+	ClockSkewFail        bool
+
+	// The retrieval of the outputs: Collector is the addr:/path they are
+	// sent to for the slaves without a Collector (-collector), Archive, if
+	// set, the archive they are streamed into (-archive) and Dest, if set,
+	// maps them to their path under the output directory (-dest-template.)
+	// Progress reports its progress (-progress), Bandwidth and Inflight, if
+	// set, are the bandwidth and the size of the retrievals shared by the
+	// run (-total-bwlimit, -max-inflight-bytes.) AllowPartialOutputs does
+	// not fail a build for some of its outputs missing
+	// (-allow-partial-outputs.)
+	Collector           string
+	Archive             *Archive
+	Dest                *DestTemplate
+	Progress            bool
+	Bandwidth           *TokenBucket
+	Inflight            *ByteBudget
+	AllowPartialOutputs bool
+
+	// The checks of the build: RequireOutputs fails a build without any
+	// output (-require-outputs), FailPatterns one whose output matches any
+	// of them (-fail-on-pattern), FailOnStderr one which wrote on its
+	// stderr (-fail-on-stderr), VerifyTypes one whose outputs are not of
+	// their expected type (-verify-types), and Baseline, unless
+	// UpdateBaseline, one whose outputs differ from it (-baseline,
+	// -update-baseline.) TailLines is the number of last lines of the
+	// output kept in the report (-tail-lines.)
+	RequireOutputs bool
+	FailPatterns   []*regexp.Regexp
+	FailOnStderr   bool
+	VerifyTypes    bool
+	Baseline       Baseline
+	UpdateBaseline bool
+	TailLines      int
+
+	// What is done with the retrieved outputs: signed with the GPG key
+	// SignKey (-sign-key), uploaded to the S3 location UploadS3, failing
+	// the build if they could not be with UploadRequired (-upload-s3,
+	// -upload-required), deduplicated into DedupStore (-dedup-store) and
+	// described by the SBOM of the local command SBOMTool (-sbom-tool.)
+	SignKey        string
+	UploadS3       string
+	UploadRequired bool
+	DedupStore     string
+	SBOMTool       string
+
+	// With Reschedule, a build whose slave became unreachable is run again
+	// after RescheduleDelay, up to MaxReschedules times
+	// (-reschedule-on-unreachable, -reschedule-delay, -max-reschedules; not
+	// by RunSlave.)
+	Reschedule      bool
+	RescheduleDelay time.Duration
+	MaxReschedules  int
+}
+
+// progressf prints a progress message with Progressf, if set.
+func (opts Options) progressf(format string, args ...interface{}) {
+	if opts.Progressf != nil {
+		opts.Progressf(format, args...)
+	}
+}
+
+// summaryf prints a line of the summary with Summaryf, if set.
+func (opts Options) summaryf(format string, args ...interface{}) {
+	if opts.Summaryf != nil {
+		opts.Summaryf(format, args...)
+	}
+}
+
+// emit sends the event ev to Events, if set.
+func (opts Options) emit(ev Event) {
+	if opts.Events != nil {
+		opts.Events(ev)
+	}
+}
+
+// RunSlave runs the whole build of the slave s (ping, setup, upload, build,
+// retrieval and clean-up), synchronously, and returns its report.
+//
+// The build is configured by opts, for the slave alone: neither
+// -wait-for-slaves, -quorum, waves, host limits nor rescheduling apply, and
+// no run-wide output (reports, state, events of the run) is written.
+func RunSlave(s Slave, opts Options) BuildReport {
+	ping := s.TimedPing(opts)
+	if ping.Err != nil {
+		return BuildReport{
+			Slave:       s,
+			Msg:         "unreachable",
+			Err:         ping.Err,
+			Unreachable: true,
+		}
+	}
+	builder, report := NewBuilder(s, ping.Latency, opts)
+	if builder == nil {
+		return report
+	}
+	defer builder.cancel()
+	report = builder.Run()
+	builder.Close()
+	return report
+}
+
+// NewBuilder prepares the build of the (reachable) slave, which answered
+// its ping in ping: its system info, logfile, secrets and work directory.
+// It returns the builder, or nil and the report of why the build cannot be
+// run.
+func NewBuilder(slave Slave, ping time.Duration, opts Options) (*Builder, BuildReport) {
+	var err error
+	if opts.Timeout > 0 {
+		slave.Timeout = opts.Timeout.String()
+	}
+	//fmt.Printf("--- slave [%s] ---\n%v\n", slave.Name, string(out))
+	t := opts.Transport
+	if t == nil {
+		t = NewTransport(&slave, opts)
+	}
+	var info map[string]string
+	if opts.SysInfo {
+		info, err = slave.sysInfo(t, opts.SysInfoCommands)
+		if err != nil {
+			log.Printf("slave [%s]: %v\n", slave.Name, err)
+		}
+	}
+	for _, dir := range []string{"logs", "output"} {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			log.Printf("could not create %s directory (err=%v)\n", dir, err)
+			return nil, BuildReport{
+				Slave: slave,
+				Msg:   "could not create the " + dir + " directory",
+				Err:   err,
+			}
+		}
+	}
+
+	logs := ""
+	fname := StageName(filepath.Join("logs", fmt.Sprintf("%s.txt", slave.Name)), opts.Stage)
+	if opts.SplitLogs {
+		logs = StageName(filepath.Join("logs", slave.Name), opts.Stage)
+		os.RemoveAll(logs)
+		err = os.MkdirAll(logs, 0755)
+		if err != nil {
+			log.Printf("could not create logs directory [%s] (err=%v)\n", logs, err)
+			return nil, BuildReport{
+				Slave: slave,
+				Msg:   "could not create the logs directory",
+				Err:   err,
+			}
+		}
+		fname = filepath.Join(logs, "setup.log")
+	}
+	secrets, err := slave.secretValues()
+	if err != nil {
+		log.Printf("slave [%s]: %v\n", slave.Name, err)
+		return nil, BuildReport{
+			Slave: slave,
+			Msg:   "invalid secrets",
+			Err:   err,
+		}
+	}
+	tmpdir, err := slave.WorkDir(opts.PersistentWorkdir)
+	if err != nil {
+		log.Printf("could not create work directory name for slave [%s] (err=%v)\n",
+			slave.Name, err,
+		)
+		return nil, BuildReport{
+			Slave: slave,
+			Msg:   "could not create a work directory",
+			Err:   err,
+		}
+	}
+	slave.Path = tmpdir
+
+	logfile, err := opts.openLog(fname, os.O_TRUNC)
+	if err != nil {
+		log.Printf(
+			"could not create logfile [%s] for slave [%s] (err=%v)\n",
+			fname, slave.Name, err,
+		)
+		logfile = &logFile{}
+	}
+	logfile.red = newRedactor(secrets)
+	if opts.Syslog != "" {
+		if w := newSyslogWriter(opts.Syslog, slave.Name, opts.LogBufferBytes); w != nil {
+			logfile.tee = w
+		}
+	}
+	if opts.Log != nil {
+		if logfile.tee != nil {
+			logfile.tee = tees{logfile.tee, keepOpen{opts.Log}}
+		} else {
+			logfile.tee = keepOpen{opts.Log}
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Builder{
+		slave:   slave,
+		w:       logfile,
+		logs:    logs,
+		info:    info,
+		secrets: secrets,
+		t:       t,
+		ping:    ping,
+		ctx:     ctx,
+		cancel:  cancel,
+		opts:    opts,
+	}, BuildReport{}
+}
+
+// Slave returns the slave of the builder, whose Path is the work directory
+// of the build.
+func (b *Builder) Slave() Slave {
+	return b.slave
+}
+
+// Cancel cancels the build.
+func (b *Builder) Cancel() {
+	b.cancel()
+}
+
+// SetInputs sets the outputs of the dependencies of the slave (DependsOn),
+// by slave, uploaded before its build.
+func (b *Builder) SetInputs(inputs map[string][]string) {
+	b.inputs = inputs
+}
+
+// Close closes the logfile of the builder, unless its build closed it, and
+// the writers its output is copied to (e.g. -syslog.) Closing it again
+// does nothing.
+func (b *Builder) Close() {
+	if b.closed {
+		return
+	}
+	b.closed = true
+	b.w.Close()
+	if w, ok := b.w.tee.(io.Closer); ok {
+		w.Close()
+	}
+}
+
+// tees copies the output of a logfile to all its writers (errors ignored),
+// flushing those which buffer it on Flush.
+type tees []io.Writer
+
+func (ws tees) Write(data []byte) (int, error) {
+	for _, w := range ws {
+		w.Write(data)
+	}
+	return len(data), nil
+}
+
+func (ws tees) Flush() {
+	for _, w := range ws {
+		if f, ok := w.(interface{ Flush() }); ok {
+			f.Flush()
+		}
+	}
+}
+
+// Close closes those of the writers which are io.Closers, and returns the
+// first error.
+func (ws tees) Close() error {
+	var err error
+	for _, w := range ws {
+		if c, ok := w.(io.Closer); ok {
+			if cerr := c.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+// keepOpen is the Log of the Options, which belongs to the caller: flushed
+// along with the logfile, but never closed.
+type keepOpen struct {
+	w io.Writer
+}
+
+func (k keepOpen) Write(data []byte) (int, error) {
+	return k.w.Write(data)
+}
+
+func (k keepOpen) Flush() {
+	if f, ok := k.w.(interface{ Flush() }); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,209 @@
+package bldbot
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// inTempDir runs f from a new temporary directory holding the build-script
+// of the slave name, removed afterwards.
+func inTempDir(t *testing.T, name, script string, f func(dir string)) {
+	dir, err := ioutil.TempDir("", "go-bldbot-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	err = os.MkdirAll(name, 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(name, "build.sh"), []byte("#!/bin/bash\n"+script), 0755)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	f(dir)
+}
+
+func TestRunSlave(t *testing.T) {
+	script := `echo building in $1
+mkdir -p $1/output && echo hello > $1/output/a.tar.gz
+`
+	inTempDir(t, "s1", script, func(dir string) {
+		s := Slave{Name: "s1", Addr: "local", RemoteTmpBase: dir}
+		report := RunSlave(s, Options{
+			Transport:      localTransport{},
+			RequireOutputs: true,
+			TailLines:      10,
+		})
+		if report.Err != nil {
+			t.Fatalf("build failed: %v (msg=%s)", report.Err, report.Msg)
+		}
+		if got, want := report.Status(), StatusOK; got != want {
+			t.Errorf("status = %q, want %q", got, want)
+		}
+		if len(report.Outputs) != 1 {
+			t.Fatalf("outputs = %v, want 1 output", report.Outputs)
+		}
+		buf, err := ioutil.ReadFile(report.Outputs[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf); got != "hello\n" {
+			t.Errorf("output = %q, want %q", got, "hello\n")
+		}
+		found := false
+		for _, line := range report.Tail {
+			found = found || strings.HasPrefix(line, "building in "+dir)
+		}
+		if !found {
+			t.Errorf("tail %q lacks the output of the build-script", report.Tail)
+		}
+	})
+}
+
+// noCopyFrom is a local transport failing to retrieve any file.
+type noCopyFrom struct {
+	localTransport
+}
+
+func (noCopyFrom) CopyFrom(ctx context.Context, srcs []string, dst string, w io.Writer) error {
+	return fmt.Errorf("connection lost")
+}
+
+func TestRunSlaveRetrieveFailure(t *testing.T) {
+	script := `mkdir -p $1/output && echo hello > $1/output/a.tar.gz
+echo BUILD OK
+`
+	inTempDir(t, "s1", script, func(dir string) {
+		s := Slave{Name: "s1", Addr: "local", RemoteTmpBase: dir}
+		report := RunSlave(s, Options{Transport: noCopyFrom{}, TailLines: 10})
+		if report.Err == nil {
+			t.Fatalf("build whose outputs could not be retrieved succeeded")
+		}
+		if report.Built.IsZero() || report.Duration <= 0 {
+			t.Errorf("built = %v, duration = %v, want those of the build", report.Built, report.Duration)
+		}
+		found := false
+		for _, line := range report.Tail {
+			found = found || line == "BUILD OK"
+		}
+		if !found {
+			t.Errorf("tail %q lacks the output of the build-script", report.Tail)
+		}
+	})
+}
+
+func TestRunSlaveChecks(t *testing.T) {
+	inTempDir(t, "s1", "echo something went wrong\n", func(dir string) {
+		s := Slave{Name: "s1", Addr: "local", RemoteTmpBase: dir}
+		opts := Options{Transport: localTransport{}}
+
+		report := RunSlave(s, opts)
+		if report.Err != nil {
+			t.Fatalf("build failed without checks: %v (msg=%s)", report.Err, report.Msg)
+		}
+
+		opts.RequireOutputs = true
+		report = RunSlave(s, opts)
+		if report.Err == nil {
+			t.Errorf("build without output succeeded with RequireOutputs")
+		}
+
+		opts.RequireOutputs = false
+		opts.FailPatterns = []*regexp.Regexp{regexp.MustCompile(`went wrong`)}
+		report = RunSlave(s, opts)
+		if report.Err == nil {
+			t.Errorf("build matching FailPatterns succeeded")
+		}
+	})
+}
+
+// closeRecorder is a Log recording whether it was closed.
+type closeRecorder struct {
+	strings.Builder
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestRunSlaveLog(t *testing.T) {
+	inTempDir(t, "s1", "echo building\n", func(dir string) {
+		s := Slave{Name: "s1", Addr: "local", RemoteTmpBase: dir}
+		w := new(closeRecorder)
+		report := RunSlave(s, Options{Transport: localTransport{}, Log: w})
+		if report.Err != nil {
+			t.Fatalf("build failed: %v (msg=%s)", report.Err, report.Msg)
+		}
+		if !strings.Contains(w.String(), "building\n") {
+			t.Errorf("Log %q lacks the output of the build-script", w.String())
+		}
+		if w.closed {
+			t.Errorf("Log was closed")
+		}
+	})
+}
+
+func TestRunSlaveArchive(t *testing.T) {
+	script := "mkdir -p $1/output && echo hello > $1/output/a.tar.gz\n"
+	inTempDir(t, "s1", script, func(dir string) {
+		s := Slave{Name: "s1", Addr: "local", RemoteTmpBase: dir}
+		buf := new(bytes.Buffer)
+		opts := Options{Transport: localTransport{}, Archive: NewArchive(buf)}
+
+		report := RunSlave(s, opts)
+		err := opts.Archive.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Err != nil {
+			t.Fatalf("build failed: %v (msg=%s)", report.Err, report.Msg)
+		}
+		hdr, err := tar.NewReader(buf).Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := hdr.Name, "s1/a.tar.gz"; got != want {
+			t.Errorf("archive entry = %q, want %q", got, want)
+		}
+
+		report = RunSlave(s, opts)
+		if report.Err == nil {
+			t.Errorf("build streamed into a closed archive succeeded")
+		}
+	})
+}
+
+func TestRunSlaveSetupFailure(t *testing.T) {
+	inTempDir(t, "s1", "echo building\n", func(dir string) {
+		err := ioutil.WriteFile("logs", nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := Slave{Name: "s1", Addr: "local", RemoteTmpBase: dir}
+		report := RunSlave(s, Options{Transport: localTransport{}})
+		if report.Err == nil {
+			t.Errorf("build without a logs directory succeeded")
+		}
+	})
+}
@@ -0,0 +1,31 @@
+package bldbot
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// uploadS3 uploads the retrieved outputs of a build to the S3 location dst
+// (bucket[/prefix]), under <dst>/<slave>/, with the aws command line tool
+// (and thus its standard credentials discovery.)
+// It returns the URLs of the uploaded objects.
+func (b Builder) uploadS3(dst string, outputs []string) ([]string, error) {
+	dst = strings.TrimSuffix(strings.TrimPrefix(dst, "s3://"), "/")
+	urls := make([]string, 0, len(outputs))
+	for _, fname := range outputs {
+		url := "s3://" + path.Join(dst, b.slave.Name, filepath.Base(fname))
+		fmt.Fprintf(b.w, "## build -- uploading [%s] to [%s]...\n", fname, url)
+		cmd := exec.Command("aws", "s3", "cp", "--no-progress", fname, url)
+		cmd.Stdout = b.w
+		cmd.Stderr = b.w
+		err := cmd.Run()
+		if err != nil {
+			return urls, fmt.Errorf("could not upload [%s] to [%s] (%v)", fname, url, err)
+		}
+		urls = append(urls, url)
+	}
+	return urls, nil
+}
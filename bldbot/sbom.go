@@ -0,0 +1,87 @@
+package bldbot
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// SBOMName returns the local path of the SBOM of the build of that slave.
+func (s *Slave) SBOMName() string {
+	return filepath.Join("output", s.Name+".sbom.json")
+}
+
+// remoteSBOM runs the SBOMCommand of the slave (from the work directory)
+// and stores its output as the SBOM of the build.
+func (b Builder) remoteSBOM() error {
+	fmt.Fprintf(b.w, "## build -- running SBOM command [%s]...\n", b.slave.SBOMCommand)
+	b.w.Sync()
+	cmd := fmt.Sprintf(
+		"cd %[1]s && BLDBOT_PATH=%[1]s && export BLDBOT_PATH && %[2]s",
+		ShellQuote(b.slave.Path), b.slave.SBOMCommand,
+	)
+	return b.writeSBOM(func(f *os.File) error {
+		return b.t.Run(b.ctx, cmd, nil, f, b.w)
+	})
+}
+
+// localSBOM runs the -sbom-tool over the retrieved outputs and stores its
+// output as the SBOM of the build.
+func (b Builder) localSBOM(outputs []string) error {
+	fmt.Fprintf(b.w, "## build -- running SBOM tool [%s]...\n", b.opts.SBOMTool)
+	b.w.Sync()
+	return b.writeSBOM(func(f *os.File) error {
+		cmd := exec.CommandContext(b.ctx, "sh", append([]string{"-c", b.opts.SBOMTool + ` "$@"`, "sh"}, outputs...)...)
+		cmd.Stdout = f
+		cmd.Stderr = b.w
+		return cmd.Run()
+	})
+}
+
+// writeSBOM writes the SBOM of the build with gen, removing it if gen
+// failed.
+func (b Builder) writeSBOM(gen func(*os.File) error) error {
+	fname := b.slave.SBOMName()
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	err = gen(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(fname)
+	}
+	return err
+}
+
+// addSBOM produces the SBOM of the (successful) build if it is to have one,
+// and adds it to its artifacts. Failing to produce it fails the build.
+func (b Builder) addSBOM(report *BuildReport) {
+	switch {
+	case b.slave.SBOMCommand != "":
+		// produced before the retrieval.
+	case b.opts.SBOMTool != "" && report.InOutputDir() && len(report.Outputs) > 0:
+		err := b.localSBOM(report.Outputs)
+		if err != nil {
+			fmt.Fprintf(b.w, "## build -- SBOM tool failed (%v)\n", err)
+			report.Msg = "SBOM tool failed"
+			report.Err = err
+			return
+		}
+	default:
+		return
+	}
+
+	fname := b.slave.SBOMName()
+	sum, size, err := HashFile(fname)
+	if err != nil {
+		report.Msg = "failed to hash the SBOM"
+		report.Err = err
+		return
+	}
+	report.SBOM = fname
+	report.Artifacts = append(report.Artifacts, ArtifactInfo{Path: fname, Size: size, SHA256: sum})
+}
@@ -0,0 +1,156 @@
+package bldbot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// redacted replaces the values of the secrets in all the output.
+const redacted = "***"
+
+// secretValues returns the values of the (local) environment variables
+// listed in the SecretEnv of that slave, and of its registry credentials.
+func (s *Slave) secretValues() (map[string]string, error) {
+	names := s.SecretEnv
+	if (s.RegistryUserEnv == "") != (s.RegistryPasswordEnv == "") {
+		return nil, fmt.Errorf("RegistryUserEnv and RegistryPasswordEnv go together")
+	}
+	if s.RegistryUserEnv != "" {
+		names = append(append([]string{}, names...), s.RegistryUserEnv, s.RegistryPasswordEnv)
+	}
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		if !validEnvName(name) {
+			return nil, fmt.Errorf("invalid SecretEnv name %q", name)
+		}
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("secret environment variable %s is not set", name)
+		}
+		if strings.ContainsAny(v, "\n\x00") {
+			return nil, fmt.Errorf("secret environment variable %s contains a newline", name)
+		}
+		values[name] = v
+	}
+	return values, nil
+}
+
+func validEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// secretsPrelude returns the shell commands reading the secrets (in the
+// order of names) from stdin into the environment of cmd, so their values
+// never appear on any command line, and the matching stdin.
+func secretsPrelude(names []string, values map[string]string, cmd string) (string, io.Reader) {
+	if len(names) <= 0 {
+		return cmd, nil
+	}
+	prelude := new(strings.Builder)
+	stdin := new(bytes.Buffer)
+	for _, name := range names {
+		fmt.Fprintf(prelude, "IFS= read -r %[1]s && export %[1]s && ", name)
+		stdin.WriteString(values[name] + "\n")
+	}
+	return prelude.String() + cmd, stdin
+}
+
+// redactor replaces the secrets in a stream of output with redacted.
+// A trailing part of the output which could be the start of a secret is held
+// back until more output (or flush) tells.
+type redactor struct {
+	secrets []string
+	pending []byte
+}
+
+func newRedactor(values map[string]string) *redactor {
+	r := &redactor{}
+	for _, v := range values {
+		if v != "" {
+			r.secrets = append(r.secrets, v)
+		}
+	}
+	if len(r.secrets) <= 0 {
+		return nil
+	}
+	return r
+}
+
+// filter returns the part of the output (data appended to what was held
+// back) which can be written out, redacted.
+func (r *redactor) filter(data []byte) []byte {
+	buf := append(r.pending, data...)
+	for _, s := range r.secrets {
+		buf = bytes.Replace(buf, []byte(s), []byte(redacted), -1)
+	}
+	hold := 0
+	for _, s := range r.secrets {
+		for n := len(s) - 1; n > hold; n-- {
+			if n <= len(buf) && bytes.HasSuffix(buf, []byte(s[:n])) {
+				hold = n
+				break
+			}
+		}
+	}
+	out := buf[:len(buf)-hold]
+	r.pending = append([]byte(nil), buf[len(buf)-hold:]...)
+	return out
+}
+
+// flush returns the output held back.
+func (r *redactor) flush() []byte {
+	out := r.pending
+	r.pending = nil
+	return out
+}
+
+// redactWriter is a writer redacting the secrets before writing to w.
+type redactWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+	r  *redactor
+}
+
+func (rw *redactWriter) Write(data []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	_, err := rw.w.Write(rw.r.filter(data))
+	return len(data), err
+}
+
+// Flush writes out the output held back.
+func (rw *redactWriter) Flush() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	_, err := rw.w.Write(rw.r.flush())
+	return err
+}
+
+// redactFile redacts the secrets in the (local) file fname.
+func redactFile(fname string, r *redactor) error {
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	out := append(r.filter(buf), r.flush()...)
+	if bytes.Equal(out, buf) {
+		return nil
+	}
+	return ioutil.WriteFile(fname, out, 0644)
+}
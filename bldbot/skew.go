@@ -0,0 +1,65 @@
+package bldbot
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockSkew returns how far ahead of the local clock that of the slave is
+// (negative if behind), against the middle of the round trip reading it.
+func (b Builder) clockSkew() (time.Duration, error) {
+	out := new(bytes.Buffer)
+	before := time.Now()
+	// (%N is not supported everywhere: then the skew is to the second.)
+	err := b.t.Run(b.ctx, "date +%s.%N", nil, out, b.w)
+	after := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(out.String())
+	s = strings.TrimSuffix(s, ".N")
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid date %q", s)
+	}
+	sec, frac := math.Modf(secs)
+	remote := time.Unix(int64(sec), int64(frac*1e9))
+	local := before.Add(after.Sub(before) / 2)
+	return remote.Sub(local), nil
+}
+
+// checkClock measures the clock skew of the slave (with -clock-skew) and
+// returns it, with an error when it is above -clock-skew and
+// -clock-skew-fail is set. A skew above -clock-skew is otherwise warned
+// about.
+func (b Builder) checkClock() (time.Duration, error) {
+	if b.opts.ClockSkew <= 0 || !b.slave.IsUnix() {
+		return 0, nil
+	}
+	skew, err := b.clockSkew()
+	if err != nil {
+		fmt.Fprintf(b.w, "## build -- could not read the clock of the slave (%v)\n", err)
+		log.Printf("slave [%s]: could not read the clock (%v)\n", b.slave.Name, err)
+		return 0, nil
+	}
+	skew = skew.Round(time.Millisecond)
+	fmt.Fprintf(b.w, "## build -- clock skew %v\n", skew)
+	if skew <= b.opts.ClockSkew && -skew <= b.opts.ClockSkew {
+		return skew, nil
+	}
+	if b.opts.ClockSkewFail {
+		fmt.Fprintf(b.w, "## build -- clock skew above -clock-skew %v\n", b.opts.ClockSkew)
+		return skew, fmt.Errorf(
+			"clock of slave [%s] is %v off (-clock-skew %v)",
+			b.slave.Name, skew, b.opts.ClockSkew,
+		)
+	}
+	fmt.Fprintf(b.w, "## build -- clock skew above -clock-skew %v (warning)\n", b.opts.ClockSkew)
+	b.opts.summaryf(">>> slave [%s]: clock skew %v (above %v)\n", b.slave.Name, skew, b.opts.ClockSkew)
+	return skew, nil
+}
@@ -0,0 +1,1698 @@
+// Package bldbot builds the projects of a list of build-slaves, over ssh (or
+// locally), and retrieves their outputs. A build is configured by the
+// Options of RunSlave; the go-bldbot command sets them from its flags.
+package bldbot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	mrand "math/rand"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type Config struct {
+	Slaves []Slave
+}
+
+type Slave struct {
+	Addr  string // slave SSH address
+	Name  string // informative name of that slave
+	Path  string // path under which all build files and artifacts are stored
+	Setup string // command run once per host (Addr) before any build on it
+	Local bool   // run the build on the local machine (also: Addr "local")
+
+	// DisplayName and Group are only used to present the builds: in the
+	// listing of the builders and in the summary, grouped by Group.
+	DisplayName string
+	Group       string
+
+	// Vars are free-form values of the slave (e.g. "arch": "arm64") for
+	// the -dest-template.
+	Vars map[string]string
+
+	// OutputDir is the directory (relative to Path) where the build-script
+	// stores its outputs (*.tar.gz.) (default: output)
+	OutputDir string
+
+	// RemoteTmpBase is the directory of the slave under which the build
+	// work directory (Path) is created. (default: /tmp)
+	RemoteTmpBase string
+
+	// RemoteLogs lists remote files (relative to Path, or absolute)
+	// retrieved into the local logs directory after the build, even if it
+	// failed.
+	RemoteLogs []string
+
+	// Nice and IONice override the -nice and -ionice settings of the run
+	// for that slave.
+	Nice   *int
+	IONice *int
+
+	// SysInfo overrides (by name) the commands run with -sysinfo for that
+	// slave. An empty command removes an entry.
+	SysInfo map[string]string
+
+	// MaxBuildDuration overrides -max-build-duration for that slave
+	// (e.g. "45m".)
+	MaxBuildDuration string
+
+	// Timeout overrides -timeout for that slave (e.g. "2h"), up to
+	// -max-timeout.
+	Timeout string
+
+	// Collector is the host the outputs are sent to (straight from the
+	// slave) instead of being retrieved locally. (default: -collector)
+	Collector *Collector
+
+	// Condition is a command run on the slave before its build: the build
+	// is skipped (with status "skipped") when it exits with a non-zero code
+	// (other than 255, which ssh uses for its own failures.)
+	Condition string
+
+	// ScriptRef pins the build-script of that slave to a revision (commit,
+	// branch or tag) of the git repository ScriptRepo (default: the current
+	// directory): <Name>/build.sh is taken from that revision, not from the
+	// worktree.
+	ScriptRef  string
+	ScriptRepo string
+
+	// WaitFor is a command polled on the slave (from the work directory),
+	// every WaitInterval (default: 2s), until it exits with code 0 before
+	// the build-script runs; e.g. to wait for a service the build needs.
+	// The build fails when it is not ready within WaitTimeout (default: 5m.)
+	WaitFor      string
+	WaitInterval string
+	WaitTimeout  string
+
+	// DependsOn names the slaves whose builds must succeed before that of
+	// this slave: their retrieved outputs are uploaded into inputs/<name>/
+	// of its work directory before its build-script runs. The build is not
+	// run when any of them failed.
+	DependsOn []string
+
+	// Targets are named build commands run on the slave (in parallel
+	// sessions, at most MaxParallelTargets at a time if set) after its
+	// build-script succeeded; e.g. to build independent artifacts
+	// concurrently. The failure of any (non-optional) target fails the
+	// build.
+	Targets            []Target
+	MaxParallelTargets int
+
+	// PostBuild lists commands run on the slave (from the work directory,
+	// also in $BLDBOT_PATH) after a successful build, before its outputs are
+	// retrieved; e.g. to strip binaries or generate checksums.
+	PostBuild []string
+
+	// SecretEnv names (local) environment variables passed to the
+	// build-script of that slave (on its stdin, never on a command line)
+	// whose values are redacted (as ***) from all the logs and reports.
+	SecretEnv []string
+
+	// RetryExitCodes restricts the re-runs of a failed build (-build-retries)
+	// to the builds whose script exited with one of these codes.
+	RetryExitCodes []int
+
+	// PushImage lists docker images, built on the slave, pushed (with the
+	// docker of the slave) after a successful build, under Registry if any
+	// (e.g. "registry.example.com/team".) RegistryUserEnv and
+	// RegistryPasswordEnv name the (local) environment variables holding the
+	// credentials of the registry, passed on stdin and redacted like
+	// SecretEnv.
+	PushImage           []string
+	Registry            string
+	RegistryUserEnv     string
+	RegistryPasswordEnv string
+
+	// Image, if any, is the docker image the build-script runs in on the
+	// slave (docker run), with the work directory mounted at the same path.
+	// CPUs and Memory limit the resources of the build container (docker
+	// run --cpus and --memory, e.g. "2" and "4g".)
+	Image  string
+	CPUs   string
+	Memory string
+
+	// Sandbox, if any, is the command template the build-script runs in on
+	// the slave, beyond docker: e.g. "systemd-nspawn -q -D /images/foo
+	// --bind={path}" or "chroot /images/foo". {script} and {path} are
+	// replaced by the (quoted) build-script and work directory, as seen in
+	// the sandbox, and appended when the template has no {script}.
+	// SandboxRoot is the directory of the slave which is the root of the
+	// sandbox (e.g. of a chroot): the work directory is then created under
+	// it, RemoteTmpBase being a directory in the sandbox.
+	Sandbox     string
+	SandboxRoot string
+
+	// FindExpr, if any, selects the outputs instead of the <OutputDir>/*.tar.gz
+	// glob: the find(1) expression (tests only, no action) run on the slave
+	// over the OutputDir, e.g. "-type f -mmin -60".
+	FindExpr string
+
+	// OutputTypes maps patterns (of the base names) of outputs to the type
+	// they are expected to be of with -verify-types, e.g. {"*.bin": "elf"},
+	// overriding the type expected from their extension.
+	OutputTypes map[string]string
+
+	// FallbackScript, if any, is a build-script (relative to the directory
+	// of the slave, like build.sh) uploaded and run in a new work directory
+	// when the build with build.sh failed (after its retries), before the
+	// build is deemed failed; e.g. a slower but more robust build.
+	FallbackScript string
+
+	// SBOMCommand, if any, is a command run on the slave (from the work
+	// directory, also in $BLDBOT_PATH) after a successful build, whose
+	// output is retrieved as the software bill of materials of the build
+	// (<Name>.sbom.json in the output directory.)
+	SBOMCommand string
+
+	// OS is the operating system of the slave (e.g. "linux" or "windows".)
+	// Any OS but windows is deemed a unix. (default: unix)
+	OS string
+
+	script  string // build-script run instead of build.sh (FallbackScript, Script of a Stage)
+	command string // command run instead of a build-script (Command of a Stage)
+}
+
+// WithScript returns (a copy of) that slave running the build-script
+// script (relative to its directory) instead of build.sh or, if set, the
+// command command (from the work directory) instead of any build-script;
+// e.g. for a stage of a -pipeline.
+func (s Slave) WithScript(script, command string) Slave {
+	s.script = script
+	s.command = command
+	return s
+}
+
+// Command returns the command run instead of any build-script, if any (see
+// WithScript.)
+func (s *Slave) Command() string {
+	return s.command
+}
+
+// Label returns the name of that slave, as presented to the user.
+func (s *Slave) Label() string {
+	if s.DisplayName == "" || s.DisplayName == s.Name {
+		return s.Name
+	}
+	return fmt.Sprintf("%s (%s)", s.DisplayName, s.Name)
+}
+
+func (s *Slave) LocalCommandFileName() string {
+	if s.script != "" {
+		return filepath.Join(s.Name, s.script)
+	}
+	return filepath.Join(s.Name, "build.sh")
+}
+
+func (s *Slave) RemoteCommandFileName() string {
+	return filepath.Join(s.Path, "build.sh")
+}
+
+// IsUnix returns whether that slave runs a unix.
+func (s *Slave) IsUnix() bool {
+	return !strings.EqualFold(s.OS, "windows")
+}
+
+// IsLocal returns whether that slave is the local machine.
+func (s *Slave) IsLocal() bool {
+	return s.Local || s.Addr == "local"
+}
+
+// priority returns the (nice/ionice) command prefix lowering the priority
+// of the builds of that slave (by default, that of opts), or "" if they run
+// at normal priority.
+func (s *Slave) priority(opts Options) string {
+	nice := opts.Nice
+	if s.Nice != nil {
+		nice = *s.Nice
+	}
+	class := opts.IONice
+	if s.IONice != nil {
+		class = *s.IONice
+	}
+	prefix := ""
+	if nice != 0 {
+		prefix += fmt.Sprintf("nice -n %d ", nice)
+	}
+	if class != 0 {
+		prefix += fmt.Sprintf("ionice -c %d ", class)
+	}
+	return prefix
+}
+
+// BuildCommand returns the remote shell command running the build-script
+// of that slave, as set by opts.
+// The build-script runs under time unless -no-time is given: its duration
+// is always measured locally anyway.
+func (s *Slave) BuildCommand(opts Options) string {
+	timer := "time "
+	if opts.NoTime {
+		timer = ""
+	}
+	cmd := fmt.Sprintf(
+		"%s%s%s %s",
+		timer,
+		s.priority(opts),
+		ShellQuote(s.RemoteCommandFileName()),
+		ShellQuote(s.Path),
+	)
+	if s.Image != "" {
+		cmd = fmt.Sprintf(
+			"%s%sdocker run --rm -i -v %s -w %s%s %s %s %s",
+			timer,
+			s.priority(opts),
+			ShellQuote(s.Path+":"+s.Path),
+			ShellQuote(s.Path),
+			s.dockerOptions(),
+			ShellQuote(s.Image),
+			ShellQuote(s.RemoteCommandFileName()),
+			ShellQuote(s.Path),
+		)
+	}
+	if s.Sandbox != "" {
+		tmpl := s.Sandbox
+		if !strings.Contains(tmpl, "{script}") {
+			tmpl += " {script} {path}"
+		}
+		cmd = timer + s.priority(opts) + strings.NewReplacer(
+			"{script}", ShellQuote(s.sandboxPath(s.RemoteCommandFileName())),
+			"{path}", ShellQuote(s.sandboxPath(s.Path)),
+		).Replace(tmpl)
+	}
+	if opts.LoginShell {
+		cmd = "bash -lc " + ShellQuote(cmd)
+	}
+	return cmd
+}
+
+// sandboxPath returns the path p (on the slave) as seen in the Sandbox of
+// that slave: relative to its SandboxRoot, if any.
+func (s *Slave) sandboxPath(p string) string {
+	if s.SandboxRoot == "" {
+		return p
+	}
+	return path.Join("/", strings.TrimPrefix(p, path.Clean(s.SandboxRoot)))
+}
+
+// TmpBase returns the directory of the slave under which the work
+// directories of its builds are created: RemoteTmpBase (default: /tmp),
+// under the SandboxRoot if any.
+func (s *Slave) TmpBase() string {
+	base := s.RemoteTmpBase
+	if base == "" {
+		base = "/tmp"
+	}
+	if s.SandboxRoot != "" {
+		base = path.Join(s.SandboxRoot, base)
+	}
+	return base
+}
+
+// dockerOptions returns the docker run options of the build container of
+// that slave: its resource limits and its secrets (passed from the
+// environment, not on the command line.)
+func (s *Slave) dockerOptions() string {
+	opts := ""
+	if s.CPUs != "" {
+		opts += " --cpus " + ShellQuote(s.CPUs)
+	}
+	if s.Memory != "" {
+		opts += " --memory " + ShellQuote(s.Memory)
+	}
+	for _, name := range s.SecretEnv {
+		opts += " -e " + name
+	}
+	return opts
+}
+
+// BuildDurationLimit returns the duration the build-script of that slave may
+// run for before the build is deemed too slow (0: no limit), def being that
+// of the slaves without a MaxBuildDuration.
+func (s *Slave) BuildDurationLimit(def time.Duration) (time.Duration, error) {
+	if s.MaxBuildDuration == "" {
+		return def, nil
+	}
+	return time.ParseDuration(s.MaxBuildDuration)
+}
+
+// RequestedTimeout returns the timeout asked for that slave, before
+// clamping, def being that of the slaves without a Timeout.
+func (s *Slave) RequestedTimeout(def time.Duration) string {
+	if s.Timeout == "" {
+		return def.String()
+	}
+	return s.Timeout
+}
+
+// TimeoutWithin returns the duration after which the build-script of that
+// slave is killed (0: no timeout), and whether it was clamped to max (0:
+// no ceiling), def being the timeout of the slaves without a Timeout.
+func (s *Slave) TimeoutWithin(def, max time.Duration) (time.Duration, bool, error) {
+	d := def
+	if s.Timeout != "" {
+		var err error
+		d, err = time.ParseDuration(s.Timeout)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	switch {
+	case max > 0 && d > max:
+		return max, true, nil
+	case max > 0 && d <= 0:
+		return max, false, nil
+	}
+	return d, false, nil
+}
+
+// retryable returns whether a build of that slave which failed with the
+// given exit code may be re-run.
+func (s *Slave) retryable(code int) bool {
+	if len(s.RetryExitCodes) <= 0 {
+		return true
+	}
+	for _, c := range s.RetryExitCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Ping checks that the slave responds, through the Transport of opts or
+// else its default transport.
+func (s *Slave) Ping(opts Options) error {
+	t := opts.Transport
+	if t == nil {
+		t = NewTransport(s, opts)
+	}
+	out := new(bytes.Buffer)
+	err := t.Run(context.Background(), "echo hello", nil, out, out)
+	if err != nil {
+		return fmt.Errorf(
+			"slave [%s] did not respond (%v: %s)",
+			s.Name, err, out.String(),
+		)
+	}
+	return err
+}
+
+type BuildReport struct {
+	Slave    Slave
+	Msg      string
+	Err      error
+	Attempts int           // number of times the whole build was attempted
+	ExitCode int           // exit code of the build-script, if it failed
+	Duration time.Duration // run time of the build-script
+	Built    time.Time     // time the build-script completed
+
+	// Images are the docker images pushed (PushImage), as <ref>@<digest>.
+	Images []string
+
+	// Rescheduled is the number of times the build was rescheduled
+	// (-reschedule-on-unreachable.)
+	Rescheduled int
+
+	// Ping is the time the slave took to answer the ping before the build.
+	Ping time.Duration
+
+	// Inputs are the outputs of the dependencies (DependsOn) uploaded
+	// before the build, by slave.
+	Inputs map[string][]string
+
+	// Targets are the results of the Targets of the slave, in order.
+	Targets []TargetResult
+
+	// SBOM is the local path of the SBOM of the build (SBOMCommand or
+	// -sbom-tool), if any.
+	SBOM string
+
+	// Skew is how far ahead of the local clock that of the slave was
+	// (-clock-skew.)
+	Skew time.Duration
+
+	// FailedOutputs are the (remote paths of the) outputs which could not be
+	// retrieved, the others being kept.
+	FailedOutputs []string
+
+	// Fallback tells the build was run again with the FallbackScript of the
+	// slave, after failing with build.sh.
+	Fallback bool
+
+	// Collected tells the outputs were sent to the collector of the slave,
+	// and are not available locally.
+	Collected bool
+	Archived  bool   // whether the outputs were streamed into the -archive
+	ScriptRev string // git revision of the build-script (ScriptRef)
+	Saved     int64  // bytes saved by deduplicating the outputs (-dedup-store)
+
+	// Artifacts describes the outputs retrieved by the build.
+	Artifacts []ArtifactInfo
+	Outputs   []string // local paths of the retrieved outputs
+	Uploads   []string // URLs of the uploaded outputs (-upload-s3)
+
+	Signatures []string // local paths of the outputs signatures (-sign-key)
+	Tail       []string // last lines of the build-script output (-tail-lines)
+
+	Cancelled   bool // whether the build was cancelled (-control-addr)
+	Unreachable bool // whether the slave did not respond to Ping
+	Aborted     bool // whether the build was not run as the run was aborted
+	Skipped     bool // whether the build was not run as its Condition did not hold
+	Detached    bool // whether the build-script was launched in the background (-detached)
+	Wave        int  // wave in which the build ran (-wave-size)
+
+	SysInfo map[string]string // system info of the slave (-sysinfo)
+}
+
+// ArtifactInfo describes an output retrieved by a build.
+type ArtifactInfo struct {
+	Path   string `json:"path"`             // local path (or addr:path on the collector)
+	Size   int64  `json:"size,omitempty"`   // size in bytes (unknown on the collector)
+	SHA256 string `json:"sha256,omitempty"` // hex SHA-256 of the content (unknown on the collector)
+}
+
+type Builder struct {
+	slave Slave
+	w     *logFile          // logfile
+	logs  string            // directory holding the per-phase logfiles (-split-logs)
+	setup *logFile          // logfile of the setup phase, when logs are split
+	host  error             // error from the setup of the host of that builder
+	info  map[string]string // system info of the slave (-sysinfo)
+
+	secrets map[string]string // values of the SecretEnv variables
+	t       Transport
+	ping    time.Duration // ping latency of the slave
+	skew    time.Duration // clock skew of the slave (-clock-skew)
+
+	inputs map[string][]string // local outputs of the dependencies, by slave (DependsOn)
+
+	ctx    context.Context // cancelled to abort the build
+	cancel context.CancelFunc
+
+	opts   Options // configuration of the build
+	closed bool    // whether Close was called
+}
+
+// phases lists the build phases with their own logfile, in order.
+var phases = []string{"setup", "upload", "wait", "build", "logs", "postbuild", "retrieve", "cleanup"}
+
+// LogNames returns the names of all the logfiles the builder may write, in
+// order (see LogFiles.)
+func (b *Builder) LogNames() []string {
+	if b.logs == "" {
+		return []string{b.w.Name()}
+	}
+	fnames := make([]string, 0, len(phases))
+	for _, phase := range phases {
+		fnames = append(fnames, filepath.Join(b.logs, phase+".log"))
+	}
+	return fnames
+}
+
+// LogFiles returns the names of the logfiles of the builder, in order.
+func (b *Builder) LogFiles() []string {
+	if b.logs == "" {
+		return []string{b.w.Name()}
+	}
+	fnames := make([]string, 0, len(phases))
+	for _, phase := range phases {
+		fname := filepath.Join(b.logs, phase+".log")
+		if _, err := os.Stat(fname); err == nil {
+			fnames = append(fnames, fname)
+		}
+	}
+	return fnames
+}
+
+// phase directs the output of the next build phase into its own logfile
+// (<logs>/<name>.log), when logs are split per phase.
+func (b *Builder) phase(name string) {
+	b.opts.emit(Event{Type: EventPhase, Slave: b.slave.Name, Phase: name})
+	if b.logs == "" {
+		return
+	}
+	fname := filepath.Join(b.logs, name+".log")
+	f, err := b.opts.openLog(fname, os.O_APPEND)
+	if err != nil {
+		fmt.Fprintf(b.w, "## build -- could not create logfile [%s] (err=%v)\n", fname, err)
+		return
+	}
+	b.endPhase()
+	if b.setup == nil {
+		b.setup = b.w
+	}
+	f.tee = b.w.tee
+	f.red = newRedactor(b.secrets)
+	b.w = f
+}
+
+// endPhase closes the logfile of the current build phase and directs the
+// output back to the setup logfile.
+func (b *Builder) endPhase() {
+	if b.setup != nil && b.w != b.setup {
+		b.w.Close()
+		b.w = b.setup
+	}
+}
+
+func (b Builder) Run() BuildReport {
+	defer b.w.Close()
+	b.opts.emit(Event{Type: EventSlaveStarted, Slave: b.slave.Name})
+
+	if len(b.info) > 0 {
+		names := make([]string, 0, len(b.info))
+		for name := range b.info {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(b.w, "## build -- sysinfo %s: %s\n", name, b.info[name])
+		}
+	}
+
+	if b.host != nil {
+		return BuildReport{
+			Slave:   b.slave,
+			Msg:     "host setup failed",
+			Err:     b.host,
+			Ping:    b.ping,
+			SysInfo: b.info,
+		}
+	}
+
+	err := b.checkPing()
+	if err != nil {
+		return BuildReport{
+			Slave:   b.slave,
+			Msg:     "slow to respond",
+			Err:     err,
+			Ping:    b.ping,
+			SysInfo: b.info,
+		}
+	}
+
+	b.skew, err = b.checkClock()
+	if err != nil {
+		return BuildReport{
+			Slave:   b.slave,
+			Msg:     "clock skew",
+			Err:     err,
+			Ping:    b.ping,
+			Skew:    b.skew,
+			SysInfo: b.info,
+		}
+	}
+
+	if b.slave.Condition != "" && !b.opts.RetrieveOnly {
+		report, skip := b.checkCondition()
+		if skip || report.Err != nil {
+			report.Ping = b.ping
+			report.Skew = b.skew
+			report.SysInfo = b.info
+			return report
+		}
+	}
+
+	timeout, clamped, err := b.slave.TimeoutWithin(b.opts.DefaultTimeout, b.opts.MaxTimeout)
+	if err != nil {
+		fmt.Fprintf(b.w, "## build -- invalid Timeout %q (%v)\n", b.slave.Timeout, err)
+		return BuildReport{
+			Slave:   b.slave,
+			Msg:     "invalid Timeout",
+			Err:     err,
+			Ping:    b.ping,
+			Skew:    b.skew,
+			SysInfo: b.info,
+		}
+	}
+	if clamped {
+		fmt.Fprintf(b.w, "## build -- timeout %s clamped to -max-timeout %v\n", b.slave.RequestedTimeout(b.opts.DefaultTimeout), timeout)
+		b.opts.summaryf(">>> slave [%s]: timeout %s clamped to -max-timeout %v\n", b.slave.Name, b.slave.RequestedTimeout(b.opts.DefaultTimeout), timeout)
+	}
+
+	var report BuildReport
+	for i := 0; i <= b.opts.Retries; i++ {
+		if i > 0 {
+			fmt.Fprintf(b.w, "## build -- retrying (attempt %d/%d)...\n",
+				i+1, b.opts.Retries+1,
+			)
+			// start again from a clean slate.
+			b.cleanup()
+			path, err := b.slave.WorkDir(b.opts.PersistentWorkdir)
+			if err != nil {
+				report.Err = err
+				report.Msg = "could not create a new work directory"
+				break
+			}
+			b.slave.Path = path
+		}
+		report = b.attempt()
+		report.Attempts = i + 1
+		if report.Err != nil && b.ctx.Err() != nil {
+			fmt.Fprintf(b.w, "## build -- cancelled\n")
+			report.Msg = "cancelled"
+			report.Cancelled = true
+			break
+		}
+		if report.Err == nil || !b.slave.retryable(report.ExitCode) {
+			break
+		}
+	}
+
+	if report.Err != nil && !report.Cancelled && b.slave.FallbackScript != "" {
+		report = b.fallback(report)
+	}
+
+	if report.Err == nil {
+		b.checkDuration(&report)
+	}
+
+	if report.Collected && (b.opts.Baseline != nil || b.opts.SignKey != "" || b.opts.UploadS3 != "") {
+		fmt.Fprintf(b.w, "## build -- outputs sent to the collector: not checked, signed nor uploaded\n")
+	}
+	if report.Archived && (b.opts.Baseline != nil || b.opts.SignKey != "" || b.opts.UploadS3 != "" || b.opts.DedupStore != "") {
+		fmt.Fprintf(b.w, "## build -- outputs streamed into the archive: not checked, signed, uploaded nor deduplicated\n")
+	}
+
+	if report.Err == nil && report.InOutputDir() && b.opts.VerifyTypes {
+		b.verifyTypes(&report)
+	}
+
+	if report.Err == nil && report.InOutputDir() && b.opts.Baseline != nil && !b.opts.UpdateBaseline {
+		b.checkBaseline(&report)
+	}
+
+	if report.Err == nil && report.InOutputDir() && b.opts.SignKey != "" {
+		b.sign(&report)
+	}
+
+	if report.Err == nil && report.InOutputDir() && b.opts.UploadS3 != "" && len(report.Outputs) > 0 {
+		files := append(append([]string{}, report.Outputs...), report.Signatures...)
+		urls, err := b.uploadS3(b.opts.UploadS3, files)
+		report.Uploads = urls
+		if err != nil {
+			fmt.Fprintf(b.w, "## build -- %v\n", err)
+			log.Printf("slave [%s]: %v\n", b.slave.Name, err)
+			if b.opts.UploadRequired {
+				report.Msg = "upload to S3 failed"
+				report.Err = err
+			}
+		}
+	}
+	if report.Err == nil && report.InOutputDir() && b.opts.DedupStore != "" && len(report.Outputs) > 0 {
+		saved, err := b.dedup(b.opts.DedupStore, &report)
+		report.Saved = saved
+		if err != nil {
+			fmt.Fprintf(b.w, "## build -- dedup failed (%v)\n", err)
+			log.Printf("slave [%s]: dedup failed (%v)\n", b.slave.Name, err)
+		}
+	}
+	report.Ping = b.ping
+	report.Skew = b.skew
+	report.SysInfo = b.info
+	report.Inputs = b.inputs
+	return report
+}
+
+// attempt runs all the phases of a build once.
+func (b Builder) attempt() BuildReport {
+	if b.opts.RetrieveOnly {
+		return b.retrieveOnly()
+	}
+
+	defer b.endPhase()
+	fmt.Fprintf(b.w, "## build -- start [%v]\n", time.Now())
+	err := CheckRemotePath(b.slave.Path)
+	if err != nil {
+		return BuildReport{
+			Slave: b.slave,
+			Msg:   "invalid remote path",
+			Err:   err,
+		}
+	}
+
+	fname, rev, remove, err := b.slave.ScriptFile()
+	if err != nil {
+		fmt.Fprintf(b.w, "## build -- could not resolve build-script at [%s] (%v)\n", b.slave.ScriptRef, err)
+		return BuildReport{
+			Slave: b.slave,
+			Msg:   fmt.Sprintf("could not resolve build-script at [%s]", b.slave.ScriptRef),
+			Err:   err,
+		}
+	}
+	defer remove()
+	if rev != "" {
+		fmt.Fprintf(b.w, "## build -- build-script from %s (%s)\n", b.slave.ScriptRef, rev)
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		log.Printf(
+			"no such file [%s] for slave [%s] (%v)\n",
+			fname, b.slave.Addr, err,
+		)
+		return BuildReport{
+			Slave: b.slave,
+			Msg:   fmt.Sprintf("no such file [%s] (err=%v)", fname, err),
+			Err:   err,
+		}
+	}
+	defer f.Close()
+
+	b.phase("upload")
+	{
+		err = b.t.Mkdir(b.ctx, b.slave.Path, b.w)
+		if err != nil {
+			// log.Printf("failed to copy [%s] to slave [%s] (err=%v)\n",
+			// 	fname, b.slave.Name, err,
+			// )
+			return BuildReport{
+				Slave: b.slave,
+				Msg:   "failed to copy [" + fname + "]",
+				Err:   err,
+			}
+		}
+	}
+	if b.opts.PersistentWorkdir {
+		// do not retrieve the outputs of a previous build.
+		odir, err := b.slave.RemoteOutputDir()
+		if err == nil && CheckRemotePath(odir) == nil {
+			err = b.t.Remove(b.ctx, odir, b.w)
+		}
+		if err != nil {
+			return BuildReport{
+				Slave: b.slave,
+				Msg:   "could not clear the outputs of the previous build",
+				Err:   err,
+			}
+		}
+	}
+
+	fmt.Fprintf(b.w, "## build -- copying build-script...\n")
+	b.w.Sync()
+	err = b.transfer("upload", func() error {
+		if b.opts.CompressUpload {
+			_, err := f.Seek(0, io.SeekStart)
+			if err != nil {
+				return err
+			}
+			return b.uploadCompressed(f, b.slave.RemoteCommandFileName())
+		}
+		return b.t.CopyTo(b.ctx, fname, b.slave.RemoteCommandFileName(), b.w)
+	})
+	if err != nil {
+		// log.Printf("failed to copy [%s] to slave [%s] (err=%v)\n",
+		// 	fname, b.slave.Name, err,
+		// )
+		return BuildReport{
+			Slave: b.slave,
+			Msg:   "failed to copy [" + fname + "]",
+			Err:   err,
+		}
+	}
+	if b.slave.IsUnix() {
+		// scp does not always preserve the permissions.
+		cmd := fmt.Sprintf("chmod +x %s", ShellQuote(b.slave.RemoteCommandFileName()))
+		err = b.t.Run(b.ctx, cmd, nil, b.w, b.w)
+		if err != nil {
+			return BuildReport{
+				Slave: b.slave,
+				Msg:   "could not make the build-script executable",
+				Err:   err,
+			}
+		}
+	}
+	if len(b.slave.DependsOn) > 0 {
+		err = b.uploadInputs()
+		if err != nil {
+			fmt.Fprintf(b.w, "## build -- %v\n", err)
+			return BuildReport{
+				Slave: b.slave,
+				Msg:   "failed to upload the inputs",
+				Err:   err,
+			}
+		}
+	}
+
+	if b.opts.Stagger > 0 {
+		delay := time.Duration(mrand.Int63n(int64(b.opts.Stagger)))
+		fmt.Fprintf(b.w, "## build -- staggering the build by %v...\n", delay)
+		select {
+		case <-time.After(delay):
+		case <-b.ctx.Done():
+			return BuildReport{
+				Slave: b.slave,
+				Msg:   "cancelled",
+				Err:   b.ctx.Err(),
+			}
+		}
+	}
+
+	if b.slave.WaitFor != "" {
+		b.phase("wait")
+		err = b.waitFor()
+		if err != nil {
+			return BuildReport{
+				Slave: b.slave,
+				Msg:   "slave not ready",
+				Err:   err,
+			}
+		}
+	}
+
+	cmd := b.slave.BuildCommand(b.opts)
+	b.phase("build")
+	if b.opts.Detached {
+		return b.detach(cmd)
+	}
+	timeout, _, _ := b.slave.TimeoutWithin(b.opts.DefaultTimeout, b.opts.MaxTimeout) // checked by run.
+	fmt.Fprintf(b.w, "## build -- running build-script...\n")
+	b.w.Sync()
+	outs := []io.Writer{b.w}
+	var scanner *patternScanner
+	if len(b.opts.FailPatterns) > 0 {
+		scanner = newPatternScanner(b.opts.FailPatterns, b.opts.LogBufferBytes)
+		outs = append(outs, scanner)
+	}
+	var tail *tailBuffer
+	if b.opts.TailLines > 0 {
+		tail = newTailBuffer(b.opts.TailLines, b.opts.LogBufferBytes)
+		outs = append(outs, tail)
+	}
+	if b.opts.BuildOutput != nil {
+		outs = append(outs, b.opts.BuildOutput(b.slave.Name))
+	}
+	if b.opts.DiffLast {
+		f, err := os.Create(BuildLogName(b.slave.Name, b.opts.Stage))
+		if err != nil {
+			fmt.Fprintf(b.w, "## build -- could not keep the build output (err=%v)\n", err)
+		} else {
+			defer f.Close()
+			outs = append(outs, f)
+		}
+	}
+	out := io.MultiWriter(outs...)
+	cmd, stdin := secretsPrelude(b.slave.SecretEnv, b.secrets, cmd)
+	var rw *redactWriter
+	if red := newRedactor(b.secrets); red != nil {
+		rw = &redactWriter{w: out, r: red}
+		out = rw
+	}
+	errout := out
+	var stderr *patternScanner
+	if b.opts.FailOnStderr {
+		stderr = newStderrScanner(b.opts.NoTime, b.opts.LogBufferBytes)
+		errout = io.MultiWriter(out, stderr)
+	}
+	ctx := b.ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(b.ctx, timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	err = b.t.Run(ctx, cmd, stdin, out, errout)
+	duration := time.Since(start)
+	if rw != nil {
+		rw.Flush()
+	}
+	fmt.Fprintf(b.w, "## build -- build-script ran for %v\n", duration)
+	timedout := err != nil && b.ctx.Err() == nil && ctx.Err() == context.DeadlineExceeded
+	if timedout {
+		fmt.Fprintf(b.w, "## build -- build-script timed out after %v\n", timeout)
+	}
+	b.fetchLogs()
+	var lines []string
+	if tail != nil {
+		lines = tail.Lines()
+	}
+	if scanner != nil && err == nil {
+		if line, ok := scanner.Match(); ok {
+			fmt.Fprintf(b.w, "## build -- forbidden pattern in output: %s\n", line)
+			return BuildReport{
+				Slave:    b.slave,
+				Msg:      fmt.Sprintf("forbidden pattern in output: %q", line),
+				Err:      fmt.Errorf("build output matched a forbidden pattern"),
+				Tail:     lines,
+				Duration: duration,
+			}
+		}
+	}
+	if stderr != nil && err == nil {
+		if line, ok := stderr.Match(); ok {
+			if red := newRedactor(b.secrets); red != nil {
+				line = string(append(red.filter([]byte(line)), red.flush()...))
+			}
+			fmt.Fprintf(b.w, "## build -- output on stderr: %s\n", line)
+			return BuildReport{
+				Slave:    b.slave,
+				Msg:      fmt.Sprintf("output on stderr: %q", line),
+				Err:      fmt.Errorf("build-script wrote on its stderr"),
+				Tail:     lines,
+				Duration: duration,
+			}
+		}
+	}
+	if err != nil {
+		// log.Printf("build failed for slave [%s] (err=%v)\n",
+		// 	b.slave.Name, err,
+		// )
+		code := ExitCode(err)
+		msg := fmt.Sprintf("build failed (exit code %d)", code)
+		switch {
+		case timedout:
+			msg = fmt.Sprintf("build timed out (after %v)", timeout)
+		case code == 137 && b.slave.Image != "":
+			msg = "build container killed (exit code 137, out of memory?)"
+			if b.slave.Memory != "" {
+				msg = fmt.Sprintf("build container killed (exit code 137, out of memory? Memory=%s)", b.slave.Memory)
+			}
+		}
+		return BuildReport{
+			Slave:    b.slave,
+			Msg:      msg,
+			Err:      err,
+			ExitCode: code,
+			Tail:     lines,
+			Duration: duration,
+		}
+	}
+
+	var targets []TargetResult
+	if len(b.slave.Targets) > 0 {
+		targets, err = b.buildTargets()
+		if err != nil {
+			fmt.Fprintf(b.w, "## build -- %v\n", err)
+			return BuildReport{
+				Slave:    b.slave,
+				Msg:      err.Error(),
+				Err:      err,
+				Targets:  targets,
+				Tail:     lines,
+				Duration: duration,
+			}
+		}
+	}
+
+	var images []string
+	if len(b.slave.PostBuild) > 0 || len(b.slave.PushImage) > 0 || b.slave.SBOMCommand != "" {
+		b.phase("postbuild")
+		for _, post := range b.slave.PostBuild {
+			fmt.Fprintf(b.w, "## build -- running post-build command [%s]...\n", post)
+			b.w.Sync()
+			cmd := fmt.Sprintf(
+				"cd %[1]s && BLDBOT_PATH=%[1]s && export BLDBOT_PATH && %[2]s",
+				ShellQuote(b.slave.Path), post,
+			)
+			err = b.t.Run(b.ctx, cmd, nil, b.w, b.w)
+			if err != nil {
+				return BuildReport{
+					Slave:    b.slave,
+					Msg:      fmt.Sprintf("post-build command failed (exit code %d)", ExitCode(err)),
+					Err:      err,
+					ExitCode: ExitCode(err),
+					Tail:     lines,
+					Duration: duration,
+				}
+			}
+		}
+		images, err = b.pushImages()
+		if err != nil {
+			fmt.Fprintf(b.w, "## build -- %v\n", err)
+			return BuildReport{
+				Slave:    b.slave,
+				Msg:      "image push failed",
+				Err:      err,
+				Images:   images,
+				Tail:     lines,
+				Duration: duration,
+			}
+		}
+		if b.slave.SBOMCommand != "" {
+			err = b.remoteSBOM()
+			if err != nil {
+				fmt.Fprintf(b.w, "## build -- SBOM command failed (%v)\n", err)
+				return BuildReport{
+					Slave:    b.slave,
+					Msg:      fmt.Sprintf("SBOM command failed (exit code %d)", ExitCode(err)),
+					Err:      err,
+					ExitCode: ExitCode(err),
+					Images:   images,
+					Tail:     lines,
+					Duration: duration,
+				}
+			}
+		}
+	}
+
+	// retrieve output
+	report := b.retrieve(BuildReport{
+		Slave:     b.slave,
+		Targets:   targets,
+		Images:    images,
+		Tail:      lines,
+		Duration:  duration,
+		ScriptRev: rev,
+		Built:     start.Add(duration),
+	})
+	if report.Err == nil {
+		b.addSBOM(&report)
+	}
+	return report
+}
+
+// retrieveOnly retrieves again the outputs left in the (persistent) work
+// directory of the slave by a previous build (-retrieve-only.)
+func (b Builder) retrieveOnly() BuildReport {
+	fmt.Fprintf(b.w, "## build -- retrieve only: reusing the work directory [%s]...\n", b.slave.Path)
+	err := b.t.Run(b.ctx, fmt.Sprintf("test -d %s", ShellQuote(b.slave.Path)), nil, b.w, b.w)
+	if err != nil {
+		return BuildReport{
+			Slave: b.slave,
+			Msg:   fmt.Sprintf("no work directory [%s] to retrieve from", b.slave.Path),
+			Err:   err,
+		}
+	}
+	return b.retrieve(BuildReport{Slave: b.slave})
+}
+
+// retrieve runs the retrieve and cleanup phases of a build, completing its
+// report.
+func (b *Builder) retrieve(report BuildReport) BuildReport {
+	b.phase("retrieve")
+	fmt.Fprintf(b.w, "## build -- retrieving output(s)...\n")
+	b.w.Sync()
+	outputs, err := b.listOutputs()
+	if err != nil {
+		report.Msg = "failed to list outputs"
+		report.Err = err
+		return report
+	}
+
+	msg := "ok"
+	local := make([]string, 0, len(outputs))
+	collector, err := b.slave.OutputCollector(b.opts.Collector)
+	if err != nil {
+		report.Msg = "invalid collector"
+		report.Err = err
+		return report
+	}
+	if len(outputs) > 0 && collector == nil && b.opts.Inflight != nil {
+		release, err := b.reserveInflight(outputs)
+		if err != nil {
+			report.Msg = "failed to read the size of outputs"
+			if b.ctx.Err() != nil {
+				report.Msg = "cancelled"
+			}
+			report.Err = err
+			return report
+		}
+		defer release()
+	}
+
+	var artifacts []ArtifactInfo
+	var failed []string
+	archived := false
+	if len(outputs) > 0 && collector != nil {
+		local, err = b.collect(collector, outputs)
+	} else if len(outputs) > 0 && b.opts.Archive != nil {
+		archived = true
+		artifacts, err = b.archiveOutputs(outputs)
+		for _, a := range artifacts {
+			local = append(local, a.Path)
+		}
+	} else if len(outputs) > 0 {
+		local, failed, err = b.retrieveOutputs(outputs)
+		if len(failed) > 0 && len(local) > 0 && b.opts.AllowPartialOutputs {
+			msg = fmt.Sprintf("ok (%d/%d output(s) not retrieved)", len(failed), len(outputs))
+			err = nil
+		}
+	} else {
+		fmt.Fprintf(b.w, "## build -- no output produced\n")
+		msg = "ok (no output produced)"
+		if b.opts.RequireOutputs {
+			err = fmt.Errorf("no output produced")
+		}
+	}
+	b.w.Sync()
+
+	if err != nil && len(failed) <= 0 {
+		report.Msg = "failed to retrieve outputs"
+		report.Err = err
+		return report
+	}
+
+	for _, fname := range local {
+		if archived {
+			// hashed while archived.
+			break
+		}
+		info := ArtifactInfo{Path: fname}
+		if collector == nil {
+			var herr error
+			info.SHA256, info.Size, herr = HashFile(fname)
+			if herr != nil {
+				report.Msg = "failed to hash outputs"
+				report.Err = herr
+				return report
+			}
+		}
+		artifacts = append(artifacts, info)
+	}
+	if err != nil {
+		// the outputs retrieved are kept (and reported.)
+		report.Msg = fmt.Sprintf("failed to retrieve %d/%d output(s)", len(failed), len(outputs))
+		report.Err = err
+		report.Outputs = local
+		report.Artifacts = artifacts
+		report.FailedOutputs = failed
+		return report
+	}
+
+	b.phase("cleanup")
+	fmt.Fprintf(b.w, "## build -- cleaning up...\n")
+	b.w.Sync()
+	report.Outputs = local
+	report.Collected = collector != nil
+	report.Archived = archived
+	report.Artifacts = artifacts
+	report.FailedOutputs = failed
+	err = b.cleanup()
+	if err != nil {
+		report.Msg = "clean-up failed"
+		report.Err = err
+		return report
+	}
+
+	report.Msg = msg
+	return report
+}
+
+// retrieveOutputs retrieves the outputs of the build into the output
+// directory, one at a time so that the failure of one does not abandon the
+// others, and returns the local paths of those retrieved, the (remote) ones
+// which could not be, and the last error.
+func (b *Builder) retrieveOutputs(outputs []string) ([]string, []string, error) {
+	local := make([]string, 0, len(outputs))
+	var failed []string
+	var last error
+	dir := "output"
+	if b.opts.Dest != nil {
+		// retrieved apart, then moved to their destination.
+		staging, err := ioutil.TempDir("output", "."+b.slave.Name+"-")
+		if err != nil {
+			return nil, outputs, err
+		}
+		defer os.RemoveAll(staging)
+		dir = staging
+	}
+	for _, output := range outputs {
+		fname := filepath.Join("output", path.Base(output))
+		if b.opts.Dest != nil {
+			var err error
+			fname, err = b.opts.Dest.path(&b.slave, output, b.opts.RunLabel)
+			if err != nil {
+				fmt.Fprintf(b.w, "## build -- could not retrieve [%s] (err=%v)\n", output, err)
+				failed = append(failed, output)
+				last = err
+				continue
+			}
+		}
+		if b.opts.DedupStore != "" {
+			// do not write through a link into the store.
+			os.Remove(fname)
+		}
+		if b.ctx.Err() != nil {
+			failed = append(failed, output)
+			last = b.ctx.Err()
+			continue
+		}
+		srcs := []string{output}
+		err := b.transfer("retrieval of "+path.Base(output), func() error {
+			if b.opts.Bandwidth != nil {
+				return b.retrieveLimited(srcs, dir)
+			} else if b.opts.Progress {
+				pw := newProgressWriter(b.w, b.slave.Name, b.opts)
+				defer pw.Flush()
+				return b.t.CopyFrom(b.ctx, srcs, dir, pw)
+			}
+			return b.t.CopyFrom(b.ctx, srcs, dir, b.w)
+		})
+		if err == nil && b.opts.Dest != nil {
+			err = moveToDest(dir, output, fname)
+		}
+		if err != nil {
+			fmt.Fprintf(b.w, "## build -- could not retrieve [%s] (err=%v)\n", output, err)
+			os.Remove(fname)
+			failed = append(failed, output)
+			last = err
+			continue
+		}
+		local = append(local, fname)
+	}
+	if last != nil {
+		last = fmt.Errorf("could not retrieve %v (%v)", failed, last)
+	}
+	return local, failed, last
+}
+
+// checkCondition runs the Condition of the slave and returns whether the
+// build is to be skipped (and its report) or a failure report if the
+// condition could not be evaluated.
+func (b Builder) checkCondition() (BuildReport, bool) {
+	fmt.Fprintf(b.w, "## build -- checking condition [%s]...\n", b.slave.Condition)
+	err := b.t.Run(b.ctx, b.slave.Condition, nil, b.w, b.w)
+	if err == nil {
+		return BuildReport{Slave: b.slave}, false
+	}
+	code := ExitCode(err)
+	if code <= 0 || code == 255 {
+		return BuildReport{
+			Slave: b.slave,
+			Msg:   "could not check condition",
+			Err:   err,
+		}, false
+	}
+	fmt.Fprintf(b.w, "## build -- condition not met (exit code %d), skipping build\n", code)
+	return BuildReport{
+		Slave:    b.slave,
+		Msg:      fmt.Sprintf("skipped (condition not met, exit code %d)", code),
+		ExitCode: code,
+		Skipped:  true,
+	}, true
+}
+
+// waitFor polls the WaitFor command of the slave until it succeeds, or
+// its WaitTimeout expires.
+func (b Builder) waitFor() error {
+	interval, timeout := 2*time.Second, 5*time.Minute
+	var err error
+	if b.slave.WaitInterval != "" {
+		interval, err = time.ParseDuration(b.slave.WaitInterval)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid WaitInterval %q", b.slave.WaitInterval)
+		}
+	}
+	if b.slave.WaitTimeout != "" {
+		timeout, err = time.ParseDuration(b.slave.WaitTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid WaitTimeout %q", b.slave.WaitTimeout)
+		}
+	}
+
+	cmd := fmt.Sprintf("cd %s && %s", ShellQuote(b.slave.Path), b.slave.WaitFor)
+	deadline := time.Now().Add(timeout)
+	for n := 1; ; n++ {
+		fmt.Fprintf(b.w, "## build -- waiting for [%s] (try %d)...\n", b.slave.WaitFor, n)
+		b.w.Sync()
+		err = b.t.Run(b.ctx, cmd, nil, b.w, b.w)
+		if err == nil {
+			fmt.Fprintf(b.w, "## build -- ready after %d tries\n", n)
+			return nil
+		}
+		if !time.Now().Add(interval).Before(deadline) {
+			fmt.Fprintf(b.w, "## build -- still not ready after %v\n", timeout)
+			return fmt.Errorf("still not ready after %v", timeout)
+		}
+		select {
+		case <-time.After(interval):
+		case <-b.ctx.Done():
+			return b.ctx.Err()
+		}
+	}
+}
+
+// checkDuration fails the (successful) build of the report if its
+// build-script ran longer than allowed for that slave.
+func (b Builder) checkDuration(report *BuildReport) {
+	max, err := b.slave.BuildDurationLimit(b.opts.MaxBuildDuration)
+	if err != nil {
+		report.Msg = "invalid MaxBuildDuration"
+		report.Err = err
+		return
+	}
+	if max <= 0 || report.Duration <= max {
+		return
+	}
+	over := report.Duration - max
+	fmt.Fprintf(b.w, "## build -- build-script too slow: %v over the %v limit\n", over, max)
+	report.Msg = fmt.Sprintf("build too slow (%v over the %v limit)", over.Round(time.Millisecond), max)
+	report.Err = fmt.Errorf("build-script ran for %v (limit: %v)", report.Duration, max)
+}
+
+// sign creates (locally) a detached GPG signature <output>.sig for each
+// retrieved output of the build. A signing failure fails the build.
+func (b Builder) sign(report *BuildReport) {
+	for _, fname := range report.Outputs {
+		sig := fname + ".sig"
+		fmt.Fprintf(b.w, "## build -- signing [%s]...\n", fname)
+		cmd := exec.Command(
+			"gpg", "--batch", "--yes",
+			"--local-user", b.opts.SignKey,
+			"--output", sig,
+			"--detach-sign", fname,
+		)
+		cmd.Stdout = b.w
+		cmd.Stderr = b.w
+		err := cmd.Run()
+		if err != nil {
+			report.Msg = "failed to sign [" + fname + "]"
+			report.Err = err
+			return
+		}
+		report.Signatures = append(report.Signatures, sig)
+	}
+}
+
+// transfer runs the copy op (to or from the slave), retrying it up to
+// -transfer-retries times, after a short pause, if it fails.
+func (b Builder) transfer(what string, op func() error) error {
+	for i := 0; ; i++ {
+		err := op()
+		if err == nil {
+			if i > 0 {
+				fmt.Fprintf(b.w, "## build -- %s succeeded (transfer attempt %d/%d)\n", what, i+1, b.opts.TransferRetries+1)
+			}
+			return nil
+		}
+		if i >= b.opts.TransferRetries || b.ctx.Err() != nil {
+			return err
+		}
+		fmt.Fprintf(b.w, "## build -- %s failed (err=%v), retrying (transfer attempt %d/%d)...\n",
+			what, err, i+2, b.opts.TransferRetries+1,
+		)
+		select {
+		case <-time.After(time.Duration(i+1) * time.Second):
+		case <-b.ctx.Done():
+			return err
+		}
+	}
+}
+
+// uploadCompressed uploads the (executable) file f to dst on the slave,
+// gzip-compressed on the wire, and verifies the sha256 of the uploaded file.
+func (b Builder) uploadCompressed(f *os.File, dst string) error {
+	hash := sha256.New()
+	pr, pw := io.Pipe()
+	go func() {
+		zw := gzip.NewWriter(pw)
+		_, err := io.Copy(zw, io.TeeReader(f, hash))
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	cmd := fmt.Sprintf(
+		"gunzip -c > %[1]s && chmod +x %[1]s && sha256sum %[1]s",
+		ShellQuote(dst),
+	)
+	out := new(bytes.Buffer)
+	err := b.t.Run(b.ctx, cmd, pr, io.MultiWriter(b.w, out), b.w)
+	pr.Close()
+	if err != nil {
+		return err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	fields := strings.Fields(out.String())
+	if len(fields) <= 0 || fields[0] != sum {
+		return fmt.Errorf("checksum mismatch for [%s] (local=%s, remote=%q)", dst, sum, out.String())
+	}
+	return nil
+}
+
+// fetchLogs retrieves the RemoteLogs of the slave into logs/<name>/ (that
+// of the stage, within a -pipeline.)
+// Logs which could not be retrieved are reported in the logfile but do not
+// fail the build.
+func (b *Builder) fetchLogs() {
+	if len(b.slave.RemoteLogs) <= 0 {
+		return
+	}
+	b.phase("logs")
+	fmt.Fprintf(b.w, "## build -- retrieving remote logs...\n")
+	b.w.Sync()
+
+	dir := StageName(filepath.Join("logs", b.slave.Name), b.opts.Stage)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		fmt.Fprintf(b.w, "## build -- could not create logs directory [%s] (err=%v)\n", dir, err)
+		return
+	}
+	for _, fname := range b.slave.RemoteLogs {
+		if !path.IsAbs(fname) {
+			fname = path.Join(b.slave.Path, fname)
+		}
+		err = b.transfer("log retrieval", func() error {
+			return b.t.CopyFrom(b.ctx, []string{fname}, dir, b.w)
+		})
+		if err != nil {
+			fmt.Fprintf(b.w, "## build -- could not retrieve remote log [%s] (err=%v)\n", fname, err)
+			continue
+		}
+		if red := newRedactor(b.secrets); red != nil {
+			err = redactFile(filepath.Join(dir, path.Base(fname)), red)
+			if err != nil {
+				fmt.Fprintf(b.w, "## build -- could not redact remote log [%s] (err=%v)\n", fname, err)
+			}
+		}
+	}
+	b.w.Sync()
+}
+
+// cleanup removes the work directory of the build from the slave, unless it
+// is persistent.
+func (b Builder) cleanup() error {
+	err := CheckRemotePath(b.slave.Path)
+	if err != nil {
+		return err
+	}
+	if b.opts.PersistentWorkdir {
+		return nil
+	}
+	return b.t.Remove(b.ctx, b.slave.Path, b.w)
+}
+
+// ExitCode returns the exit code of the command which failed with err, or -1
+// if it could not be determined.
+func ExitCode(err error) int {
+	if err, ok := err.(*exec.ExitError); ok {
+		if status, ok := err.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
+
+// CheckRemotePath checks that p is an absolute remote path which can be
+// safely handed to the remote shell (and to rm -rf.)
+func CheckRemotePath(p string) error {
+	if !strings.HasPrefix(p, "/") {
+		return fmt.Errorf("remote path [%s] is not absolute", p)
+	}
+	if path.Clean(p) != p {
+		return fmt.Errorf("remote path [%s] is not clean", p)
+	}
+	if strings.Count(p, "/") < 2 {
+		return fmt.Errorf("remote path [%s] is too close to the root", p)
+	}
+	for _, c := range p {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.ContainsRune("/._-+", c):
+		default:
+			return fmt.Errorf("remote path [%s] contains unsafe character %q", p, c)
+		}
+	}
+	return nil
+}
+
+// ShellQuote quotes s so it is interpreted as a single word by a POSIX
+// shell.
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// WorkDir returns the path of the work directory of the next build of that
+// slave (under its TmpBase): a new one, or the same one for all runs if
+// persistent (<base>/go-bldbot-<name>, -persistent-workdir.)
+func (s *Slave) WorkDir(persistent bool) (string, error) {
+	if !persistent {
+		return newWorkDir(s.TmpBase())
+	}
+	base := s.TmpBase()
+	name := []rune(s.Name)
+	for i, c := range name {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.ContainsRune("._-+", c):
+		default:
+			name[i] = '_'
+		}
+	}
+	return path.Join(base, "go-bldbot-"+string(name)), nil
+}
+
+// newWorkDir returns a new (unique) path for a build work directory under
+// the remote directory base: <base>/go-bldbot-<date>-<random>.
+// Nothing is created, neither locally nor on the slave.
+func newWorkDir(base string) (string, error) {
+	if base == "" {
+		base = "/tmp"
+	}
+	buf := make([]byte, 8)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf(
+		"go-bldbot-%s-%s",
+		time.Now().Format("20060102"),
+		hex.EncodeToString(buf),
+	)
+	return path.Join(base, name), nil
+}
+
+// RemoteOutputDir returns the remote directory where the build-script of that
+// slave stores its outputs.
+func (s *Slave) RemoteOutputDir() (string, error) {
+	odir := s.OutputDir
+	if odir == "" {
+		odir = "output"
+	}
+	if path.IsAbs(odir) || strings.HasPrefix(path.Clean(odir), "..") {
+		return "", fmt.Errorf("output directory [%s] is not relative to the work directory", odir)
+	}
+	return path.Join(s.Path, odir), nil
+}
+
+// listOutputs returns the remote paths of the outputs (<OutputDir>/*.tar.gz)
+// produced by the build-script.
+// An empty list (and no error) is returned when the build did not produce
+// anything.
+func (b Builder) listOutputs() ([]string, error) {
+	dir, err := b.slave.RemoteOutputDir()
+	if err != nil {
+		return nil, err
+	}
+	if b.slave.FindExpr != "" {
+		return b.findOutputs(dir)
+	}
+	out := new(bytes.Buffer)
+	cmd := fmt.Sprintf(
+		"for f in %s/*.tar.gz; do if [ -f \"$f\" ]; then echo \"$f\"; fi; done", // */ dumb emacs
+		ShellQuote(dir),
+	)
+	err = b.t.Run(b.ctx, cmd, nil, out, b.w)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make([]string, 0)
+	for _, line := range strings.Split(out.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		outputs = append(outputs, line)
+	}
+	return outputs, nil
+}
+
+// findOutputs returns the remote paths of the outputs matching the FindExpr
+// of the slave under dir. The regular files found are listed NUL-delimited,
+// so any file name is parsed safely; their base names must be unique as
+// they are all retrieved into the same local directory.
+func (b Builder) findOutputs(dir string) ([]string, error) {
+	out := new(bytes.Buffer)
+	cmd := fmt.Sprintf(
+		"if [ -d %[1]s ]; then find %[1]s \\( %[2]s \\) -type f -print0; fi",
+		ShellQuote(dir), b.slave.FindExpr,
+	)
+	err := b.t.Run(b.ctx, cmd, nil, out, b.w)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make([]string, 0)
+	seen := make(map[string]string)
+	for _, fname := range strings.Split(out.String(), "\x00") {
+		if fname == "" {
+			continue
+		}
+		base := path.Base(fname)
+		if other, dup := seen[base]; dup {
+			return nil, fmt.Errorf("outputs [%s] and [%s] have the same name", other, fname)
+		}
+		seen[base] = fname
+		outputs = append(outputs, fname)
+	}
+	sort.Strings(outputs)
+	return outputs, nil
+}
+
+// SetupHosts runs the Setup command of each host, once per unique address
+// and one host after the other, before any build is launched.
+// The output of a host setup is written to the logfile of all the builders
+// of that host and, if it failed, these builders are marked as failed.
+func SetupHosts(builders []*Builder) {
+	addrs := make([]string, 0, len(builders))
+	hosts := make(map[string][]*Builder)
+	for _, builder := range builders {
+		addr := builder.slave.Addr
+		if _, dup := hosts[addr]; !dup {
+			addrs = append(addrs, addr)
+		}
+		hosts[addr] = append(hosts[addr], builder)
+	}
+
+	for _, addr := range addrs {
+		cmd := ""
+		ws := make([]io.Writer, 0, len(hosts[addr]))
+		for _, builder := range hosts[addr] {
+			ws = append(ws, builder.w)
+			if builder.slave.Setup == "" {
+				continue
+			}
+			if cmd == "" {
+				cmd = builder.slave.Setup
+				continue
+			}
+			if builder.slave.Setup != cmd {
+				log.Printf(
+					"slave [%s] declares a different setup for host [%s] (ignored)\n",
+					builder.slave.Name, addr,
+				)
+			}
+		}
+		if cmd == "" {
+			continue
+		}
+
+		hosts[addr][0].opts.progressf(">>> setting up host [%s]...\n", addr)
+		w := io.MultiWriter(ws...)
+		fmt.Fprintf(w, "## setup -- host [%s]...\n", addr)
+		t := hosts[addr][0].t
+		err := t.Run(context.Background(), cmd, nil, w, w)
+		if err != nil {
+			log.Printf("setup of host [%s] failed (err=%v)\n", addr, err)
+			for _, builder := range hosts[addr] {
+				builder.host = err
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package bldbot
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestCheckRemotePath(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		ok   bool
+	}{
+		{"/tmp/go-bldbot-20240101-abcdef", true},
+		{"/home/build/work_dir/v1.2+3", true},
+		{"/tmp/..x", true},
+		{"", false},
+		{"/", false},
+		{"/tmp", false},
+		{"tmp/go-bldbot", false},
+		{"/tmp/..", false},
+		{"/tmp/a/../b", false},
+		{"/tmp/a/", false},
+		{"/tmp//a", false},
+		{"/tmp/a'b", false},
+		{`/tmp/a"b`, false},
+		{"/tmp/a\nb", false},
+		{"/tmp/a b", false},
+		{"/tmp/$(reboot)", false},
+		{"/tmp/a;b", false},
+	} {
+		err := CheckRemotePath(tc.path)
+		if tc.ok && err != nil {
+			t.Errorf("CheckRemotePath(%q) = %v, want nil", tc.path, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("CheckRemotePath(%q) = nil, want an error", tc.path)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"plain",
+		"two words",
+		"it's",
+		"''",
+		`"double" \back\slash`,
+		"$HOME `id` $(id) ;|&<>*?",
+		"new\nline\ttab",
+		"-n",
+	} {
+		out, err := exec.Command("sh", "-c", "printf '%s' "+ShellQuote(s)).Output()
+		if err != nil {
+			t.Fatalf("sh with %q: %v", ShellQuote(s), err)
+		}
+		if got := string(out); got != s {
+			t.Errorf("ShellQuote(%q) gives %q back", s, got)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package bldbot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// sshArgv returns the command line running prog (ssh, scp or rsync) with
+// the given arguments, prefixed with the common ssh options opts.
+func sshArgv(opts []string, prog string, args ...string) []string {
+	if prog == "rsync" {
+		if len(opts) > 0 {
+			args = append([]string{"-e", "ssh " + strings.Join(opts, " ")}, args...)
+		}
+		return append([]string{prog}, args...)
+	}
+	return append(append([]string{prog}, opts...), args...)
+}
+
+// command returns the command performing the operation of the given kind
+// (running the remote shell command cmd, if any) with prog and args, as
+// rewritten by the command hook of the transport. It waits for its turn
+// when the rate of connections is limited.
+func (t *sshTransport) command(ctx context.Context, kind, cmd, prog string, args ...string) (*exec.Cmd, error) {
+	argv, err := t.hook.apply(Operation{
+		Kind: kind,
+		Addr: t.addr,
+		Cmd:  cmd,
+		Argv: sshArgv(t.options, prog, args...),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if t.connections != nil {
+		t.connections.take(1)
+	}
+	return exec.CommandContext(ctx, argv[0], argv[1:]...), nil
+}
+
+// sshTransport is the default transport, using ssh and scp.
+type sshTransport struct {
+	addr        string       // SSH address of the slave
+	progress    bool         // retrieve files with rsync --progress (if available)
+	mode        string       // how files are copied (-transport)
+	options     []string     // options of all the ssh/scp/rsync commands
+	connections *TokenBucket // rate limit of the connections (-connect-rate), if any
+	hook        CommandHook
+}
+
+func (t *sshTransport) Run(ctx context.Context, cmd string, stdin io.Reader, stdout, stderr io.Writer) error {
+	ssh, err := t.command(ctx, OpRun, cmd, "ssh", t.addr, cmd)
+	if err != nil {
+		return err
+	}
+	ssh.Stdin = stdin
+	ssh.Stdout = stdout
+	ssh.Stderr = stderr
+	return ssh.Run()
+}
+
+func (t *sshTransport) Mkdir(ctx context.Context, dir string, w io.Writer) error {
+	return t.Run(ctx, fmt.Sprintf("mkdir -p %s", ShellQuote(dir)), nil, w, w)
+}
+
+func (t *sshTransport) CopyTo(ctx context.Context, src, dst string, w io.Writer) error {
+	if t.useTar() {
+		return t.tarCopyTo(ctx, src, dst, w)
+	}
+	if t.mode == "rsync" {
+		return t.rsync(ctx, OpCopyTo, w, "Total bytes sent", src, fmt.Sprintf("%s:%s", t.addr, dst))
+	}
+	scp, err := t.command(ctx, OpCopyTo, "", "scp", src, fmt.Sprintf("%s:%s", t.addr, dst))
+	if err != nil {
+		return err
+	}
+	return t.scp(scp, w, func() error { return t.tarCopyTo(ctx, src, dst, w) })
+}
+
+func (t *sshTransport) CopyFrom(ctx context.Context, srcs []string, dst string, w io.Writer) error {
+	args := make([]string, 0, len(srcs)+2)
+	prog := "scp"
+	switch {
+	case t.progress && haveRsync():
+		// rsync reports the progress of the transfer(s).
+		prog = "rsync"
+		args = append(args, "--progress")
+	case t.useTar():
+		return t.tarCopyFrom(ctx, srcs, dst, w)
+	case t.mode == "rsync":
+		for _, src := range srcs {
+			args = append(args, fmt.Sprintf("%s:%s", t.addr, src))
+		}
+		args = append(args, dst+"/.")
+		return t.rsync(ctx, OpCopyFrom, w, "Total bytes received", args...)
+	}
+	for _, src := range srcs {
+		args = append(args, fmt.Sprintf("%s:%s", t.addr, src))
+	}
+	args = append(args, dst+"/.")
+	cmd, err := t.command(ctx, OpCopyFrom, "", prog, args...)
+	if err != nil {
+		return err
+	}
+	if prog == "scp" {
+		return t.scp(cmd, w, func() error { return t.tarCopyFrom(ctx, srcs, dst, w) })
+	}
+	cmd.Stdout = w
+	cmd.Stderr = w
+	return cmd.Run()
+}
+
+// rsync runs rsync (with delta transfers) with the given arguments and
+// reports the number of bytes it actually transferred (its stat).
+func (t *sshTransport) rsync(ctx context.Context, kind string, w io.Writer, stat string, args ...string) error {
+	cmd, err := t.command(ctx, kind, "", "rsync", append([]string{"-a", "--stats"}, args...)...)
+	if err != nil {
+		return err
+	}
+	out := new(bytes.Buffer)
+	cmd.Stdout = io.MultiWriter(w, out)
+	cmd.Stderr = w
+	err = cmd.Run()
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, stat+":") {
+			fmt.Fprintf(w, "## build -- rsync transferred %s bytes\n", strings.TrimSpace(strings.TrimPrefix(line, stat+":")))
+		}
+	}
+	return nil
+}
+
+func (t *sshTransport) Remove(ctx context.Context, dir string, w io.Writer) error {
+	return t.Run(ctx, fmt.Sprintf("/bin/rm -rf %s", ShellQuote(dir)), nil, w, w)
+}
@@ -0,0 +1,81 @@
+package bldbot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// defaultSysInfo lists the commands run on the slaves with -sysinfo, by name.
+var defaultSysInfo = map[string]string{
+	"uname":  "uname -a",
+	"cpus":   "nproc 2>/dev/null || getconf _NPROCESSORS_ONLN",
+	"memory": "awk '/^MemTotal:/ {print $2 \" kB\"}' /proc/meminfo 2>/dev/null || sysctl -n hw.memsize",
+	"go":     "go version",
+	"gcc":    "gcc --version | head -n 1",
+}
+
+// sysInfoMarker delimits the outputs of the info commands.
+const sysInfoMarker = "### go-bldbot-sysinfo "
+
+// sysInfoCommands returns the info commands of that slave, by name: the
+// defaults, overridden by overrides (-sysinfo-command) and then by the
+// SysInfo of the slave. An empty command removes an entry.
+func (s *Slave) sysInfoCommands(overrides map[string]string) map[string]string {
+	cmds := make(map[string]string)
+	for _, m := range []map[string]string{defaultSysInfo, overrides, s.SysInfo} {
+		for name, cmd := range m {
+			if cmd == "" {
+				delete(cmds, name)
+				continue
+			}
+			cmds[name] = cmd
+		}
+	}
+	return cmds
+}
+
+// sysInfo runs (in a single remote shell, through t) the info commands of
+// that slave (see sysInfoCommands) and returns their (trimmed) outputs, by
+// name. A failing command yields its output, if any, as its value.
+func (s *Slave) sysInfo(t Transport, overrides map[string]string) (map[string]string, error) {
+	cmds := s.sysInfoCommands(overrides)
+	names := make([]string, 0, len(cmds))
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	script := new(bytes.Buffer)
+	for _, name := range names {
+		fmt.Fprintf(script, "echo %s; (%s) 2>&1\n", ShellQuote(sysInfoMarker+name), cmds[name])
+	}
+	out := new(bytes.Buffer)
+	err := t.Run(context.Background(), "sh", script, out, out)
+	if err != nil {
+		return nil, fmt.Errorf("could not collect system info (%v: %s)", err, out.String())
+	}
+
+	info := make(map[string]string, len(names))
+	name := ""
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, sysInfoMarker) {
+			name = strings.TrimPrefix(line, sysInfoMarker)
+			info[name] = ""
+			continue
+		}
+		if name == "" {
+			continue
+		}
+		if info[name] != "" {
+			info[name] += "\n"
+		}
+		info[name] += line
+	}
+	for name, v := range info {
+		info[name] = strings.TrimSpace(v)
+	}
+	return info, nil
+}
@@ -0,0 +1,21 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package bldbot
+
+import (
+	"log"
+)
+
+// syslogWriter is never created: there is no log/syslog on this system.
+type syslogWriter struct{}
+
+// newSyslogWriter logs that -syslog is not supported and returns nil.
+func newSyslogWriter(server, name string, max int) *syslogWriter {
+	log.Printf("slave [%s]: -syslog is not supported on this platform\n", name)
+	return nil
+}
+
+func (s *syslogWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
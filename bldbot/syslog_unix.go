@@ -0,0 +1,103 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package bldbot
+
+import (
+	"bytes"
+	"log"
+	"log/syslog"
+	"strings"
+	"sync"
+)
+
+// syslogWriter sends the lines written to it to a syslog server.
+// Failures to reach the server are logged once and the output is then
+// dropped: they never fail the build.
+type syslogWriter struct {
+	mu     sync.Mutex
+	w      *syslog.Writer
+	name   string // name of the slave
+	server string // syslog server (-syslog)
+	buf    []byte // incomplete last line
+	max    int    // cap on buf (-log-buffer-bytes, 0: none)
+	failed bool
+}
+
+// newSyslogWriter connects to the syslog server (as given to -syslog),
+// tagging the messages with the name of the slave, the incomplete lines
+// being sent once longer than max (0: never.) It returns nil if the server
+// could not be reached.
+func newSyslogWriter(server, name string, max int) *syslogWriter {
+	tag := "go-bldbot/" + name
+	prio := syslog.LOG_INFO | syslog.LOG_USER
+	var (
+		w   *syslog.Writer
+		err error
+	)
+	if server == "local" {
+		w, err = syslog.New(prio, tag)
+	} else {
+		network, addr := "udp", server
+		if i := strings.Index(addr, "://"); i >= 0 {
+			network, addr = addr[:i], addr[i+3:]
+		}
+		w, err = syslog.Dial(network, addr, prio, tag)
+	}
+	if err != nil {
+		log.Printf("slave [%s]: could not connect to syslog [%s] (%v)\n", name, server, err)
+		return nil
+	}
+	return &syslogWriter{w: w, name: name, server: server, max: max}
+}
+
+func (s *syslogWriter) Write(data []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed {
+		return len(data), nil
+	}
+	s.buf = append(s.buf, data...)
+	for {
+		i := bytes.IndexByte(s.buf, '\n')
+		if i < 0 {
+			break
+		}
+		s.send(string(s.buf[:i]))
+		s.buf = s.buf[i+1:]
+	}
+	if s.max > 0 && len(s.buf) > s.max {
+		s.send(string(s.buf))
+		s.buf = nil
+	}
+	return len(data), nil
+}
+
+// Flush sends the incomplete last line, if any.
+func (s *syslogWriter) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.buf) > 0 && !s.failed {
+		s.send(string(s.buf))
+	}
+	s.buf = nil
+}
+
+// Close flushes the incomplete last line and closes the connection to the
+// syslog server.
+func (s *syslogWriter) Close() error {
+	s.Flush()
+	return s.w.Close()
+}
+
+func (s *syslogWriter) send(line string) {
+	line = strings.TrimRight(line, "\r")
+	if line == "" {
+		return
+	}
+	err := s.w.Info(line)
+	if err != nil {
+		s.failed = true
+		log.Printf("slave [%s]: could not write to syslog [%s], dropping the output (%v)\n", s.name, s.server, err)
+	}
+}
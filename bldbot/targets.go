@@ -0,0 +1,143 @@
+package bldbot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Target is a named build command of a slave, run (from the work directory,
+// also in $BLDBOT_PATH, with its name in $BLDBOT_TARGET) after its
+// build-script, concurrently with its other targets.
+type Target struct {
+	Name    string
+	Command string
+
+	// Optional tells the failure of that target does not fail the build.
+	Optional bool
+}
+
+// TargetResult is the outcome of the build of a target.
+type TargetResult struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"` // StatusOK or StatusFailed
+	ExitCode int     `json:"exit_code,omitempty"`
+	Duration float64 `json:"duration"` // in seconds
+	Optional bool    `json:"optional,omitempty"`
+}
+
+// buildTargets runs the Targets of the slave, in parallel sessions (at most
+// MaxParallelTargets at a time, if set), and returns their results (in
+// order) with an error when any of the (non-optional) targets failed. The
+// output of each target is written into the logfile line by line, prefixed
+// with its name.
+func (b Builder) buildTargets() ([]TargetResult, error) {
+	n := b.slave.MaxParallelTargets
+	if n <= 0 || n > len(b.slave.Targets) {
+		n = len(b.slave.Targets)
+	}
+	fmt.Fprintf(b.w, "## build -- building %d target(s), %d at a time...\n", len(b.slave.Targets), n)
+	b.w.Sync()
+
+	results := make([]TargetResult, len(b.slave.Targets))
+	errs := make([]error, len(b.slave.Targets))
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for i, target := range b.slave.Targets {
+		wg.Add(1)
+		go func(i int, target Target) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i], errs[i] = b.buildTarget(target)
+		}(i, target)
+	}
+	wg.Wait()
+
+	for i, res := range results {
+		if errs[i] == nil {
+			continue
+		}
+		if res.Optional {
+			fmt.Fprintf(b.w, "## build -- optional target [%s] failed (exit code %d)\n", res.Name, res.ExitCode)
+			continue
+		}
+		return results, fmt.Errorf("target [%s] failed (exit code %d)", res.Name, res.ExitCode)
+	}
+	return results, nil
+}
+
+// buildTarget runs the command of the target and returns its result.
+func (b Builder) buildTarget(target Target) (TargetResult, error) {
+	res := TargetResult{Name: target.Name, Status: StatusOK, Optional: target.Optional}
+	out := &linePrefixer{w: b.w, prefix: "[" + target.Name + "] ", max: b.opts.LogBufferBytes}
+	fmt.Fprintf(out, "## build -- running target [%s]...\n", target.Command)
+	cmd := fmt.Sprintf(
+		"cd %[1]s && BLDBOT_PATH=%[1]s BLDBOT_TARGET=%[2]s && export BLDBOT_PATH BLDBOT_TARGET && %[3]s",
+		ShellQuote(b.slave.Path), ShellQuote(target.Name), target.Command,
+	)
+	cmd, stdin := secretsPrelude(b.slave.SecretEnv, b.secrets, cmd)
+	start := time.Now()
+	err := b.t.Run(b.ctx, cmd, stdin, out, out)
+	res.Duration = time.Since(start).Seconds()
+	out.Flush()
+	if err != nil {
+		res.Status = StatusFailed
+		res.ExitCode = ExitCode(err)
+	}
+	fmt.Fprintf(b.w, "## build -- target [%s]: %s (%v)\n",
+		target.Name, res.Status, time.Duration(res.Duration*float64(time.Second)).Round(time.Millisecond),
+	)
+	return res, err
+}
+
+// linePrefixer writes whole lines, prefixed, to w (so that the lines of
+// concurrent writers are not interleaved.) Flush writes out the last
+// partial line.
+type linePrefixer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+	max    int // cap on buf (-log-buffer-bytes, 0: none)
+}
+
+func (lp *linePrefixer) Write(data []byte) (int, error) {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	lp.buf = append(lp.buf, data...)
+	i := bytes.LastIndexByte(lp.buf, '\n')
+	if i < 0 && lp.max > 0 && len(lp.buf) > lp.max {
+		// written out as a line (-log-buffer-bytes.)
+		lp.buf = append(lp.buf, '\n')
+		i = len(lp.buf) - 1
+	}
+	if i < 0 {
+		return len(data), nil
+	}
+	lines := lp.buf[:i+1]
+	out := make([]byte, 0, len(lines)+len(lp.prefix)*bytes.Count(lines, []byte{'\n'}))
+	for len(lines) > 0 {
+		j := bytes.IndexByte(lines, '\n')
+		out = append(append(out, lp.prefix...), lines[:j+1]...)
+		lines = lines[j+1:]
+	}
+	lp.buf = append(lp.buf[:0], lp.buf[i+1:]...)
+	_, err := lp.w.Write(out)
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Flush writes out the partial line held, if any.
+func (lp *linePrefixer) Flush() {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	if len(lp.buf) > 0 {
+		lp.w.Write(append(append([]byte(lp.prefix), lp.buf...), '\n'))
+		lp.buf = nil
+	}
+}
@@ -0,0 +1,155 @@
+package bldbot
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// noScp records the addresses of the slaves found without scp (-transport
+// auto), so the fallback is only detected (and logged) once per host.
+var noScp struct {
+	sync.Mutex
+	addrs map[string]bool
+}
+
+// useTar returns whether files are to be copied with tar over ssh.
+func (t *sshTransport) useTar() bool {
+	switch t.mode {
+	case "tar":
+		return true
+	case "auto":
+		noScp.Lock()
+		defer noScp.Unlock()
+		return noScp.addrs[t.addr]
+	}
+	return false
+}
+
+// scp runs the scp command cmd and, with -transport auto, falls back to
+// tar over ssh (running fallback) if scp is not available on the slave.
+func (t *sshTransport) scp(cmd *exec.Cmd, w io.Writer, fallback func() error) error {
+	out := new(bytes.Buffer)
+	cmd.Stdout = w
+	cmd.Stderr = io.MultiWriter(w, out)
+	err := cmd.Run()
+	if err == nil || t.mode != "auto" || !strings.Contains(out.String(), "not found") {
+		return err
+	}
+
+	noScp.Lock()
+	if noScp.addrs == nil {
+		noScp.addrs = make(map[string]bool)
+	}
+	if !noScp.addrs[t.addr] {
+		noScp.addrs[t.addr] = true
+		log.Printf("slave [%s]: scp not available, falling back to tar over ssh\n", t.addr)
+	}
+	noScp.Unlock()
+	fmt.Fprintf(w, "## build -- scp not available, falling back to tar over ssh\n")
+	return fallback()
+}
+
+// tarCopyTo copies the local file src to the file dst on the slave, as a
+// tar stream extracted by the remote tar.
+func (t *sshTransport) tarCopyTo(ctx context.Context, src, dst string, w io.Writer) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := tw.WriteHeader(&tar.Header{
+			Name:    path.Base(dst),
+			Mode:    int64(fi.Mode().Perm()),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		})
+		if err == nil {
+			_, err = io.Copy(tw, f)
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	cmd := fmt.Sprintf("tar xf - -C %s", ShellQuote(path.Dir(dst)))
+	err = t.Run(ctx, cmd, pr, w, w)
+	pr.Close()
+	return err
+}
+
+// tarCopyFrom copies the files srcs from the slave into the local directory
+// dst, from a tar stream created by the remote tar.
+func (t *sshTransport) tarCopyFrom(ctx context.Context, srcs []string, dst string, w io.Writer) error {
+	args := make([]string, 0, 2*len(srcs))
+	for _, src := range srcs {
+		args = append(args, "-C", ShellQuote(path.Dir(src)), ShellQuote(path.Base(src)))
+	}
+	cmd := "tar cf - " + strings.Join(args, " ")
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- untar(pr, dst)
+		// drain the stream if extraction stopped early.
+		io.Copy(ioutil.Discard, pr)
+	}()
+	err := t.Run(ctx, cmd, nil, pw, w)
+	pw.CloseWithError(err)
+	xerr := <-done
+	if err != nil {
+		return err
+	}
+	return xerr
+}
+
+// untar extracts the regular files of the tar stream r into the directory
+// dst, under their base names.
+func untar(r io.Reader, dst string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		fname := filepath.Join(dst, path.Base(hdr.Name))
+		f, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		err = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package bldbot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Transport performs the remote operations of a build on a slave.
+// All the operations write their (diagnostic) output to w.
+type Transport interface {
+	// Run runs the shell command cmd on the slave. stdin may be nil.
+	Run(ctx context.Context, cmd string, stdin io.Reader, stdout, stderr io.Writer) error
+
+	// Mkdir creates the directory dir (and its parents) on the slave.
+	Mkdir(ctx context.Context, dir string, w io.Writer) error
+
+	// CopyTo copies the local file src to the file dst on the slave.
+	CopyTo(ctx context.Context, src, dst string, w io.Writer) error
+
+	// CopyFrom copies the files srcs from the slave into the local
+	// directory dst.
+	CopyFrom(ctx context.Context, srcs []string, dst string, w io.Writer) error
+
+	// Remove removes the directory dir (and its content) from the slave.
+	Remove(ctx context.Context, dir string, w io.Writer) error
+}
+
+// Kinds of operations run as commands by the transports.
+const (
+	OpRun      = "run"       // run a shell command on the slave
+	OpCopyTo   = "copy-to"   // copy a file to the slave
+	OpCopyFrom = "copy-from" // copy files from the slave
+)
+
+// Operation describes an operation of a transport about to be run as a
+// (local) command.
+type Operation struct {
+	Kind string   `json:"kind"`          // one of the Op* constants
+	Addr string   `json:"addr"`          // address of the slave
+	Cmd  string   `json:"cmd,omitempty"` // remote shell command (OpRun)
+	Argv []string `json:"argv"`          // command line about to be run
+}
+
+// CommandHook returns the command line actually run to perform op, e.g.
+// wrapping op.Argv (which it may modify) in sudo or a proxy.
+// A nil hook runs op.Argv unchanged.
+type CommandHook func(op Operation) ([]string, error)
+
+func (hook CommandHook) apply(op Operation) ([]string, error) {
+	if hook == nil {
+		return op.Argv, nil
+	}
+	argv, err := hook(op)
+	if err != nil {
+		return nil, fmt.Errorf("command hook failed for %s on [%s] (%v)", op.Kind, op.Addr, err)
+	}
+	if len(argv) <= 0 {
+		return nil, fmt.Errorf("command hook returned an empty command for %s on [%s]", op.Kind, op.Addr)
+	}
+	return argv, nil
+}
+
+// NewTransport returns the default transport of the slave s (ssh and scp,
+// or the local shell), as configured by opts (whose Transport is ignored.)
+func NewTransport(s *Slave, opts Options) Transport {
+	if s.IsLocal() {
+		return localTransport{hook: opts.CommandHook}
+	}
+	return &sshTransport{
+		addr:        s.Addr,
+		progress:    opts.Progress,
+		mode:        opts.TransportMode,
+		options:     opts.SSHOptions,
+		connections: opts.Connections,
+		hook:        opts.CommandHook,
+	}
+}
+
+// localTransport runs the builds of the local pseudo-slave on the local
+// machine.
+type localTransport struct {
+	hook CommandHook
+}
+
+func (t localTransport) Run(ctx context.Context, cmd string, stdin io.Reader, stdout, stderr io.Writer) error {
+	sh, err := exec.LookPath("bash")
+	if err != nil {
+		sh = "/bin/sh"
+	}
+	argv, err := t.hook.apply(Operation{
+		Kind: OpRun,
+		Addr: "local",
+		Cmd:  cmd,
+		Argv: []string{sh, "-c", cmd},
+	})
+	if err != nil {
+		return err
+	}
+	c := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	c.Stdin = stdin
+	c.Stdout = stdout
+	c.Stderr = stderr
+	return c.Run()
+}
+
+func (localTransport) Mkdir(ctx context.Context, dir string, w io.Writer) error {
+	return os.MkdirAll(dir, 0755)
+}
+
+func (localTransport) CopyTo(ctx context.Context, src, dst string, w io.Writer) error {
+	return copyFile(dst, src)
+}
+
+func (localTransport) CopyFrom(ctx context.Context, srcs []string, dst string, w io.Writer) error {
+	for _, src := range srcs {
+		err := copyFile(filepath.Join(dst, filepath.Base(src)), src)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (localTransport) Remove(ctx context.Context, dir string, w io.Writer) error {
+	return os.RemoveAll(dir)
+}
+
+// copyFile copies the file src to dst, preserving its permissions.
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
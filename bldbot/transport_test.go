@@ -0,0 +1,37 @@
+package bldbot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCommandHook(t *testing.T) {
+	var ops []Operation
+	hook := func(op Operation) ([]string, error) {
+		ops = append(ops, op)
+		return append([]string{"env", "HOOKED=yes"}, op.Argv...), nil
+	}
+	tr := NewTransport(&Slave{Addr: "local"}, Options{CommandHook: hook})
+	var out bytes.Buffer
+	err := tr.Run(context.Background(), "echo $HOOKED", nil, &out, &out)
+	if err != nil {
+		t.Fatalf("Run = %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "yes" {
+		t.Errorf("hooked command printed %q, want %q", got, "yes")
+	}
+	if len(ops) != 1 || ops[0].Kind != OpRun || ops[0].Addr != "local" || ops[0].Cmd != "echo $HOOKED" {
+		t.Errorf("hook got %+v, want one run of the command on local", ops)
+	}
+
+	hook = func(op Operation) ([]string, error) {
+		return nil, errors.New("denied")
+	}
+	tr = NewTransport(&Slave{Addr: "local"}, Options{CommandHook: hook})
+	if err := tr.Run(context.Background(), "true", nil, &out, &out); err == nil {
+		t.Errorf("Run with a failing hook = nil, want an error")
+	}
+}
@@ -0,0 +1,7 @@
+package main
+
+import (
+	"flag"
+)
+
+var g_total_bwlimit = flag.Int("total-bwlimit", 0, "bandwidth (in KB/s) shared by all the outputs retrievals of the run (0: unlimited)")
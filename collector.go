@@ -0,0 +1,7 @@
+package main
+
+import (
+	"flag"
+)
+
+var g_collector = flag.String("collector", "", "host (addr:/path) the slaves send their outputs to, instead of retrieving them locally")
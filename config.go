@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// loadFlagDefaults reads the flag defaults from a defaults file and
+// applies those of the flags which were not explicitly set on the command
+// line.
+//
+// The file is TOML, of one 'key = value' pair per line where key is the
+// name of a flag. Only the subset needed by flags is supported: bare or
+// quoted keys, basic ("...", with TOML escapes) and literal ('...')
+// strings, integers, floats and booleans; durations are strings. Tables,
+// dotted keys, arrays, multi-line strings and dates are rejected:
+//
+//	# bldbot.toml
+//	parallel = false
+//	build-retries = 2
+//	timeout = "45m"
+//	config = 'fleet.yaml'
+func loadFlagDefaults(fname string) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	seen := make(map[string]bool)
+	scan := bufio.NewScanner(f)
+	lineno := 0
+	for scan.Scan() {
+		lineno++
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return fmt.Errorf("%s:%d: tables are not supported", fname, lineno)
+		}
+		key, rest, err := defaultKey(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", fname, lineno, err)
+		}
+		value, err := defaultValue(rest)
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid value for [%s] (%v)", fname, lineno, key, err)
+		}
+		if flag.Lookup(key) == nil || key == "defaults" {
+			return fmt.Errorf("%s:%d: unknown option [%s]", fname, lineno, key)
+		}
+		if seen[key] {
+			return fmt.Errorf("%s:%d: duplicate key [%s]", fname, lineno, key)
+		}
+		seen[key] = true
+		if set[key] {
+			// command line wins.
+			continue
+		}
+		err = flag.Set(key, value)
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid value for [%s] (%v)", fname, lineno, key, err)
+		}
+	}
+	return scan.Err()
+}
+
+// defaultKey splits a line of a defaults file into its (unquoted) key and
+// what follows the '='.
+func defaultKey(line string) (string, string, error) {
+	var key, rest string
+	var err error
+	switch line[0] {
+	case '"', '\'':
+		key, rest, err = tomlString(line)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid key (%v)", err)
+		}
+	default:
+		i := 0
+		for i < len(line) && isBareKey(line[i]) {
+			i++
+		}
+		key, rest = line[:i], line[i:]
+	}
+	rest = strings.TrimLeft(rest, " \t")
+	switch {
+	case key == "":
+		return "", "", fmt.Errorf("expected 'key = value'")
+	case strings.HasPrefix(rest, "."):
+		return "", "", fmt.Errorf("dotted keys are not supported")
+	case !strings.HasPrefix(rest, "="):
+		return "", "", fmt.Errorf("expected 'key = value'")
+	}
+	return key, strings.TrimSpace(rest[1:]), nil
+}
+
+func isBareKey(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+var (
+	tomlInt   = regexp.MustCompile(`^([+-]?(0|[1-9](_?[0-9])*)|0x[0-9a-fA-F](_?[0-9a-fA-F])*|0o[0-7](_?[0-7])*|0b[01](_?[01])*)$`)
+	tomlFloat = regexp.MustCompile(`^[+-]?((0|[1-9](_?[0-9])*)(\.[0-9](_?[0-9])*)?([eE][+-]?[0-9](_?[0-9])*)?|inf|nan)$`)
+)
+
+// defaultValue returns the value of a line of a defaults file, without its
+// trailing comment, as given to its flag: strings unquoted, integers in
+// decimal.
+func defaultValue(s string) (string, error) {
+	var value, rest string
+	switch {
+	case s == "" || s[0] == '#':
+		return "", fmt.Errorf("missing value")
+	case strings.HasPrefix(s, `"""`) || strings.HasPrefix(s, "'''"):
+		return "", fmt.Errorf("multi-line strings are not supported")
+	case s[0] == '"' || s[0] == '\'':
+		var err error
+		value, rest, err = tomlString(s)
+		if err != nil {
+			return "", err
+		}
+	case s[0] == '[':
+		return "", fmt.Errorf("arrays are not supported (use a comma-separated string)")
+	case s[0] == '{':
+		return "", fmt.Errorf("inline tables are not supported")
+	default:
+		i := strings.IndexAny(s, " \t#")
+		if i < 0 {
+			i = len(s)
+		}
+		value, rest = s[:i], s[i:]
+		switch {
+		case value == "true" || value == "false":
+		case tomlInt.MatchString(value):
+			n, err := strconv.ParseInt(strings.Replace(value, "_", "", -1), 0, 64)
+			if err != nil {
+				return "", err
+			}
+			value = strconv.FormatInt(n, 10)
+		case tomlFloat.MatchString(value):
+			value = strings.Replace(value, "_", "", -1)
+		default:
+			return "", fmt.Errorf("invalid value %q (strings, e.g. durations, must be quoted)", value)
+		}
+	}
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected %q after the value", rest)
+	}
+	return value, nil
+}
+
+// tomlString returns the (single-line) TOML string s starts with, basic
+// ("...", with escapes) or literal ('...'), unquoted, and what follows it.
+func tomlString(s string) (string, string, error) {
+	if s[0] == '\'' {
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+	var buf strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return buf.String(), s[i+1:], nil
+		case c < 0x20 && c != '\t' || c == 0x7f:
+			return "", "", fmt.Errorf("control character in string")
+		case c != '\\':
+			buf.WriteByte(c)
+			continue
+		}
+		i++
+		if i >= len(s) {
+			break
+		}
+		switch s[i] {
+		case 'b':
+			buf.WriteByte('\b')
+		case 't':
+			buf.WriteByte('\t')
+		case 'n':
+			buf.WriteByte('\n')
+		case 'f':
+			buf.WriteByte('\f')
+		case 'r':
+			buf.WriteByte('\r')
+		case '"', '\\':
+			buf.WriteByte(s[i])
+		case 'u', 'U':
+			n := 4
+			if s[i] == 'U' {
+				n = 8
+			}
+			if i+n >= len(s) {
+				return "", "", fmt.Errorf("invalid escape \\%s", s[i:])
+			}
+			r, err := strconv.ParseUint(s[i+1:i+1+n], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(r)) {
+				return "", "", fmt.Errorf("invalid escape \\%s", s[i:i+1+n])
+			}
+			buf.WriteRune(rune(r))
+			i += n
+		default:
+			return "", "", fmt.Errorf("invalid escape \\%c", s[i])
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string")
+}
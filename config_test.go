@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func TestDefaultValue(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{in: "2", want: "2", ok: true},
+		{in: "-3", want: "-3", ok: true},
+		{in: "1_000", want: "1000", ok: true},
+		{in: "0x1f", want: "31", ok: true},
+		{in: "0.5", want: "0.5", ok: true},
+		{in: "false # no parallelism", want: "false", ok: true},
+		{in: `"fleet.yaml"`, want: "fleet.yaml", ok: true},
+		{in: `"45m"`, want: "45m", ok: true},
+		{in: `"a#b" # comment`, want: "a#b", ok: true},
+		{in: `"say \"hi\""`, want: `say "hi"`, ok: true},
+		{in: `"tab\there \u00e9"`, want: "tab\there \u00e9", ok: true},
+		{in: `'C:\path\to'`, want: `C:\path\to`, ok: true},
+		{in: `'it''s'`},
+		{in: ""},
+		{in: "# only a comment"},
+		{in: "45m"},
+		{in: "012"},
+		{in: "True"},
+		{in: `"unterminated`},
+		{in: `'unterminated`},
+		{in: `"a" b`},
+		{in: `"\x41"`},
+		{in: `"""multi"""`},
+		{in: "[a, b]"},
+		{in: "{a = 1}"},
+		{in: "1979-05-27"},
+	} {
+		got, err := defaultValue(tc.in)
+		if tc.ok && (err != nil || got != tc.want) {
+			t.Errorf("defaultValue(%q) = %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("defaultValue(%q) = %q, want an error", tc.in, got)
+		}
+	}
+}
+
+func TestDefaultKey(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		key  string
+		rest string
+		ok   bool
+	}{
+		{in: "build-retries = 2", key: "build-retries", rest: "2", ok: true},
+		{in: "parallel=false", key: "parallel", rest: "false", ok: true},
+		{in: `"max_timeout" = "1h"`, key: "max_timeout", rest: `"1h"`, ok: true},
+		{in: "'timeout' = '5m'", key: "timeout", rest: "'5m'", ok: true},
+		{in: "ssh.cipher = 'aes'"},
+		{in: "no value"},
+		{in: "= 2"},
+	} {
+		key, rest, err := defaultKey(tc.in)
+		if tc.ok && (err != nil || key != tc.key || rest != tc.rest) {
+			t.Errorf("defaultKey(%q) = %q, %q, %v, want %q, %q", tc.in, key, rest, err, tc.key, tc.rest)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("defaultKey(%q) = %q, %q, want an error", tc.in, key, rest)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+// logPoll is the period at which a followed logfile is read again.
+const logPoll = 500 * time.Millisecond
+
+// controller serves the HTTP control endpoint of a run (-control-addr):
+//
+//	POST /cancel/<slave>  cancels the build of that slave
+//	GET  /log/<slave>     streams the logfile of that slave (tail -f style)
+type controller struct {
+	mu       sync.Mutex
+	builders map[string]*bldbot.Builder
+	logs     map[string][]string      // slave -> logfiles, in order
+	done     map[string]chan struct{} // slave -> closed once its build completed
+	streams  sync.WaitGroup           // GET /log requests in progress
+}
+
+func newController() *controller {
+	ctl := &controller{
+		builders: make(map[string]*bldbot.Builder),
+		logs:     make(map[string][]string),
+		done:     make(map[string]chan struct{}),
+	}
+	addEventSink(ctl.handleEvent)
+	return ctl
+}
+
+func (ctl *controller) handleEvent(ev bldbot.Event) {
+	if ev.Type != bldbot.EventSlaveCompleted {
+		return
+	}
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	if done, ok := ctl.done[ev.Slave]; ok {
+		select {
+		case <-done:
+		default:
+			close(done)
+		}
+	}
+}
+
+// register makes the builders of a (new) run controllable.
+func (ctl *controller) register(builders []*bldbot.Builder) {
+	if ctl == nil {
+		return
+	}
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	for _, builder := range builders {
+		name := builder.Slave().Name
+		ctl.builders[name] = builder
+		ctl.done[name] = make(chan struct{})
+		ctl.logs[name] = builder.LogNames()
+	}
+}
+
+// serve starts serving the control endpoint on addr, in the background.
+func (ctl *controller) serve(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cancel/", ctl.handleCancel)
+	mux.HandleFunc("/log/", ctl.handleLog)
+	go func() {
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			log.Printf("control endpoint [%s] failed (err=%v)\n", addr, err)
+		}
+	}()
+}
+
+func (ctl *controller) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/cancel/")
+
+	ctl.mu.Lock()
+	builder, ok := ctl.builders[name]
+	ctl.mu.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("no such slave [%s]", name), http.StatusNotFound)
+		return
+	}
+
+	log.Printf("cancelling build of slave [%s]...\n", name)
+	builder.Cancel()
+	fmt.Fprintf(w, "cancelled [%s]\n", name)
+}
+
+// drain gives the logfile streams in progress (of completed builds) up to
+// timeout to end, before the program exits.
+func (ctl *controller) drain(timeout time.Duration) {
+	if ctl == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		ctl.streams.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+// handleLog streams the logfile(s) of a slave as they are written, until its
+// build completes. With -split-logs, the logfiles of the phases are streamed
+// in turn.
+func (ctl *controller) handleLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/log/")
+
+	ctl.mu.Lock()
+	fnames, ok := ctl.logs[name]
+	done := ctl.done[name]
+	ctl.mu.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("no such slave [%s]", name), http.StatusNotFound)
+		return
+	}
+
+	ctl.streams.Add(1)
+	defer ctl.streams.Done()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	for i, fname := range fnames {
+		// the logfile is over once a later one exists or the build completed.
+		over := func() bool {
+			select {
+			case <-done:
+				return true
+			default:
+			}
+			for _, next := range fnames[i+1:] {
+				if _, err := os.Stat(next); err == nil {
+					return true
+				}
+			}
+			return false
+		}
+		err := follow(w, r, fname, over, done)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// follow copies the logfile fname to w as it grows, until over tells no
+// more output will be written to it (or the client went away.) A logfile
+// which does not exist (yet) is waited for, unless over. Closing wake
+// ends the wait for more output.
+func follow(w http.ResponseWriter, r *http.Request, fname string, over func() bool, wake <-chan struct{}) error {
+	var f *os.File
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	for {
+		// checked before reading, so that the last output is not missed.
+		last := over()
+		if f == nil {
+			var err error
+			f, err = os.Open(fname)
+			if err != nil && last {
+				return nil
+			}
+		}
+		if f != nil {
+			_, err := io.Copy(w, f)
+			if err != nil {
+				return err
+			}
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+		}
+		if last {
+			return nil
+		}
+		select {
+		case <-time.After(logPoll):
+		case <-wake:
+		case <-r.Context().Done():
+			return r.Context().Err()
+		}
+	}
+}
@@ -0,0 +1,7 @@
+package main
+
+import (
+	"flag"
+)
+
+var g_dedup_store = flag.String("dedup-store", "", "content-addressed directory where the retrieved outputs are deduplicated (outputs become links into it)")
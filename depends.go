@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+// builtOutputs records the outputs retrieved (locally) by the successful
+// builds of the earlier runs of the process (-watch rebuilds, stages of a
+// -pipeline), for the dependents built without their dependencies.
+var builtOutputs = make(map[string][]string)
+
+// recordOutputs records the outputs of the successful builds of the reports
+// into builtOutputs.
+func recordOutputs(reports []bldbot.BuildReport) {
+	for _, report := range reports {
+		if report.Status() == bldbot.StatusOK && report.InOutputDir() {
+			builtOutputs[report.Slave.Name] = report.Outputs
+		}
+	}
+}
+
+// priorOutputs returns the outputs of the last successful build of the
+// dependency dep not built in this run: those of an earlier run of the
+// process, or else those recorded in the state file st. They must still be
+// there.
+func priorOutputs(dep string, st states) ([]string, error) {
+	files, ok := builtOutputs[dep]
+	if !ok {
+		state, known := st[dep]
+		if !known || state.Outputs == nil {
+			return nil, fmt.Errorf("dependency [%s] was not built (nor its outputs recorded)", dep)
+		}
+		files = state.Outputs
+	}
+	for _, fname := range files {
+		_, err := os.Stat(fname)
+		if err != nil {
+			return nil, fmt.Errorf("output [%s] of dependency [%s] is gone (%v)", fname, dep, err)
+		}
+	}
+	return files, nil
+}
+
+// hasDependencies returns whether any of the slaves DependsOn another.
+func hasDependencies(slaves []bldbot.Slave) bool {
+	for _, slave := range slaves {
+		if len(slave.DependsOn) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// dependencyStages splits the builders into stages, in order, each builder
+// in the stage after the last of (the builders of) its dependencies. A
+// dependency without a builder (unreachable, or not in the run) does not
+// hold back its dependents: linkInputs falls back to its prior outputs.
+// (Cycles are rejected by CheckSlaves: their builders would end up in the
+// last stage.)
+func dependencyStages(builders []*bldbot.Builder) [][]*bldbot.Builder {
+	stage := make(map[string]int, len(builders))
+	for _, builder := range builders {
+		stage[builder.Slave().Name] = -1
+	}
+	var stages [][]*bldbot.Builder
+	rest := builders
+	for len(rest) > 0 {
+		var ready, blocked []*bldbot.Builder
+		for _, builder := range rest {
+			ok := true
+			for _, dep := range builder.Slave().DependsOn {
+				if n, known := stage[dep]; known && (n < 0 || n >= len(stages)) {
+					ok = false
+				}
+			}
+			if ok {
+				ready = append(ready, builder)
+			} else {
+				blocked = append(blocked, builder)
+			}
+		}
+		if len(ready) <= 0 {
+			// a cycle: left to fail on its dependencies.
+			return append(stages, blocked)
+		}
+		for _, builder := range ready {
+			stage[builder.Slave().Name] = len(stages)
+		}
+		stages = append(stages, ready)
+		rest = blocked
+	}
+	return stages
+}
+
+// linkInputs sets the inputs of the builders of a stage from the reports of
+// their dependencies, and returns those which can run and the reports of
+// those which cannot, a dependency of theirs having failed, or not been
+// built in the run without prior outputs (see priorOutputs, st being the
+// state file.)
+func linkInputs(stage []*bldbot.Builder, reports []bldbot.BuildReport, st states) ([]*bldbot.Builder, []bldbot.BuildReport) {
+	byName := make(map[string]bldbot.BuildReport, len(reports))
+	for _, report := range reports {
+		byName[report.Slave.Name] = report
+	}
+	runnable := make([]*bldbot.Builder, 0, len(stage))
+	var blocked []bldbot.BuildReport
+	for _, builder := range stage {
+		inputs := make(map[string][]string, len(builder.Slave().DependsOn))
+		var broken error
+		for _, dep := range builder.Slave().DependsOn {
+			report, ok := byName[dep]
+			switch {
+			case !ok:
+				inputs[dep], broken = priorOutputs(dep, st)
+			case report.Status() != bldbot.StatusOK:
+				broken = fmt.Errorf("dependency [%s] %s", dep, report.Status())
+			case !report.InOutputDir():
+				broken = fmt.Errorf("outputs of dependency [%s] were not retrieved locally", dep)
+			default:
+				inputs[dep] = report.Outputs
+			}
+			if broken != nil {
+				break
+			}
+		}
+		if broken != nil {
+			builder.Close()
+			blocked = append(blocked, bldbot.BuildReport{
+				Slave:   builder.Slave(),
+				Msg:     "not run (" + broken.Error() + ")",
+				Err:     broken,
+				Aborted: true,
+			})
+			continue
+		}
+		builder.SetInputs(inputs)
+		runnable = append(runnable, builder)
+	}
+	return runnable, blocked
+}
+
+// baseNames returns the base names of the files, comma-separated.
+func baseNames(files []string) string {
+	names := make([]string, 0, len(files))
+	for _, fname := range files {
+		names = append(names, filepath.Base(fname))
+	}
+	return strings.Join(names, ", ")
+}
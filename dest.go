@@ -0,0 +1,7 @@
+package main
+
+import (
+	"flag"
+)
+
+var g_dest_template = flag.String("dest-template", "", "Go template of the local path (under output/) of each retrieved output, e.g. '{{.Vars.arch}}/{{.Vars.version}}/{{.File}}' (fields: Slave, Vars, File, Label; function: env)")
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+var g_detached = flag.Bool("detached", false, "launch the build-scripts in the background on the slaves (surviving disconnections) and exit, the builds being collected later with the collect command")
+var g_detached_state = flag.String("detached-state", "detached.json", "file where the builds launched with -detached are recorded, until collected")
+
+// loadDetached loads the detached builds recorded in fname (none if it
+// does not exist.)
+func loadDetached(fname string) ([]bldbot.DetachedBuild, error) {
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var builds []bldbot.DetachedBuild
+	err = json.Unmarshal(buf, &builds)
+	return builds, err
+}
+
+// saveDetached writes the detached builds into fname (removing it once they
+// were all collected.)
+func saveDetached(fname string, builds []bldbot.DetachedBuild) error {
+	if len(builds) <= 0 {
+		err := os.Remove(fname)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return err
+	}
+	buf, err := json.MarshalIndent(builds, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := fname + ".tmp"
+	err = ioutil.WriteFile(tmp, append(buf, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, fname)
+}
+
+// recordDetached adds the builds of the run launched in the background to
+// the detached builds of fname.
+func recordDetached(fname, run string, reports []bldbot.BuildReport) error {
+	builds, err := loadDetached(fname)
+	if err != nil {
+		return err
+	}
+	for _, report := range reports {
+		if !report.Detached {
+			continue
+		}
+		builds = append(builds, bldbot.DetachedBuild{
+			Run:     run,
+			Slave:   report.Slave.Name,
+			Addr:    report.Slave.Addr,
+			Path:    report.Slave.Path,
+			Started: report.Built,
+		})
+	}
+	return saveDetached(fname, builds)
+}
+
+// detachedConflicts returns the settings of the run and of the slaves which
+// -detached does not support: those acting on the build-script while it
+// runs or on its outcome, before its outputs are retrieved (the collect
+// command only retrieves them.)
+func detachedConflicts(slaves []bldbot.Slave) []error {
+	var errs []error
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"-timeout", *g_timeout > 0},
+		{"-max-build-duration", *g_max_build_duration > 0},
+		{"-fail-on-pattern", len(g_fail_patterns) > 0},
+		{"-fail-on-stderr", *g_fail_on_stderr},
+		{"-tail-lines", *g_tail_lines > 0},
+		{"-sbom-tool", *g_sbom_tool != ""},
+		{"-verify-types", *g_verify_types},
+		{"-baseline", *g_baseline != ""},
+		{"-sign-key", *g_sign_key != ""},
+		{"-upload-s3", *g_upload_s3 != ""},
+		{"-dedup-store", *g_dedup_store != ""},
+	} {
+		if f.set {
+			errs = append(errs, fmt.Errorf("%s is not supported with -detached", f.name))
+		}
+	}
+	for _, slave := range slaves {
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"Timeout", slave.Timeout != ""},
+			{"MaxBuildDuration", slave.MaxBuildDuration != ""},
+			{"SecretEnv", len(slave.SecretEnv) > 0},
+			{"Targets", len(slave.Targets) > 0},
+			{"PostBuild", len(slave.PostBuild) > 0},
+			{"PushImage", len(slave.PushImage) > 0},
+			{"SBOMCommand", slave.SBOMCommand != ""},
+			{"FallbackScript", slave.FallbackScript != ""},
+		} {
+			if f.set {
+				errs = append(errs, fmt.Errorf("slave [%s]: %s is not supported with -detached", slave.Name, f.name))
+			}
+		}
+	}
+	return errs
+}
+
+// runCollect implements the collect command: it collects the detached
+// builds (of the given -run, or all) which completed, retrieving their
+// outputs, and returns the exit code of the program: 0 if all of them were
+// collected and succeeded, 1 if any failed, 3 if some are still running (or
+// their slave did not respond.)
+func runCollect(slaves []bldbot.Slave, args []string) (code int) {
+	fset := flag.NewFlagSet("collect", flag.ExitOnError)
+	run := fset.String("run", "", "only collect the builds of that run (default: all)")
+	wait := fset.Duration("wait", 0, "how long to wait for the builds still running to complete (0: do not wait)")
+	fset.Parse(args)
+
+	if *g_lock_file != "" {
+		lock, err := acquireLock(*g_lock_file, *g_lock_wait, *g_lock_max_age)
+		if err != nil {
+			log.Printf("collect: %v\n", err)
+			return 2
+		}
+		defer lock.release()
+	}
+
+	builds, err := loadDetached(*g_detached_state)
+	if err != nil {
+		log.Printf("collect: could not load [%s] (err=%v)\n", *g_detached_state, err)
+		return 2
+	}
+	if *g_archive != "" {
+		archive, err = bldbot.CreateArchive(*g_archive)
+		if err != nil {
+			log.Printf("collect: could not create archive [%s] (err=%v)\n", *g_archive, err)
+			return 2
+		}
+		defer func() {
+			err := archive.Close()
+			archive = nil
+			if err != nil {
+				log.Printf("collect: could not write archive [%s] (err=%v)\n", *g_archive, err)
+				code = 2
+			}
+		}()
+	}
+	bySlave := make(map[string]bldbot.Slave, len(slaves))
+	for _, slave := range slaves {
+		bySlave[slave.Name] = slave
+	}
+
+	deadline := time.Now().Add(*wait)
+	for {
+		pending := make([]bldbot.DetachedBuild, 0, len(builds))
+		running := 0
+		for _, build := range builds {
+			slave, ok := bySlave[build.Slave]
+			if (*run != "" && build.Run != *run) || !ok {
+				if !ok {
+					log.Printf("collect: no slave [%s] for the build of run %s\n", build.Slave, build.Run)
+				}
+				pending = append(pending, build)
+				continue
+			}
+			report, done := bldbot.Collect(slave, build, flagOptions())
+			if !done {
+				pending = append(pending, build)
+				running++
+				continue
+			}
+			if !summarize(report) {
+				code = 1
+			}
+		}
+		builds = pending
+		err = saveDetached(*g_detached_state, builds)
+		if err != nil {
+			log.Printf("collect: could not update [%s] (err=%v)\n", *g_detached_state, err)
+			return 2
+		}
+		if running <= 0 || !time.Now().Add(waitPoll).Before(deadline) {
+			if running > 0 {
+				summaryf(">>> collect: %d build(s) still running\n", running)
+				if code == 0 {
+					code = 3
+				}
+			}
+			return code
+		}
+		time.Sleep(waitPoll)
+	}
+}
+
+// detachedPaths returns the work directories of the detached builds not
+// collected yet, which are kept by gc.
+func detachedPaths() map[string]bool {
+	builds, err := loadDetached(*g_detached_state)
+	if err != nil {
+		log.Printf("gc: could not load [%s] (err=%v)\n", *g_detached_state, err)
+	}
+	paths := make(map[string]bool, len(builds))
+	for _, build := range builds {
+		paths[build.Addr+":"+build.Path] = true
+	}
+	return paths
+}
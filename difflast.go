@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+var g_diff_last = flag.Bool("diff-last", false, "keep the build output of the last successful build of each slave and show how a failed build differs from it")
+
+// lastLogName returns the name of the file holding the output of the
+// build-script of the last successful build of that slave, within the
+// -pipeline stage if any (-diff-last.)
+func lastLogName(name, stage string) string {
+	return bldbot.StageName(filepath.Join("logs", name+".last-ok.txt"), stage)
+}
+
+// diffLast keeps the build output of the successful builds for the next
+// runs and prints the differences between the output of each failed build
+// and that of the last successful one of its slave.
+func diffLast(reports []bldbot.BuildReport) {
+	for _, report := range reports {
+		name := report.Slave.Name
+		cur := bldbot.BuildLogName(name, pipelineStage)
+		if _, err := os.Stat(cur); err != nil {
+			// not built in this run.
+			continue
+		}
+		if !report.Failed() {
+			err := os.Rename(cur, lastLogName(name, pipelineStage))
+			if err != nil {
+				log.Printf("could not keep the build output of slave [%s] (err=%v)\n", name, err)
+			}
+			continue
+		}
+
+		last := lastLogName(name, pipelineStage)
+		if _, err := os.Stat(last); err != nil {
+			summaryf(">>> slave [%s]: no previous successful build to diff against\n", name)
+			continue
+		}
+		out := new(bytes.Buffer)
+		cmd := exec.Command(
+			"diff", "-u",
+			"--label", fmt.Sprintf("%s (last successful)", name),
+			"--label", fmt.Sprintf("%s (this run)", name),
+			last, cur,
+		)
+		cmd.Stdout = out
+		cmd.Stderr = out
+		err := cmd.Run()
+		os.Remove(cur)
+		if err != nil && bldbot.ExitCode(err) != 1 {
+			log.Printf("could not diff the build output of slave [%s] (err=%v)\n%s", name, err, out.String())
+			continue
+		}
+		summaryf(">>> slave [%s]: build output vs the last successful build:\n", name)
+		if out.Len() == 0 {
+			summaryf("(identical)\n")
+			continue
+		}
+		summaryf("%s", out.String())
+	}
+}
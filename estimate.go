@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+var g_estimate = flag.Bool("estimate", false, "print an estimate of the data uploaded to and downloaded from each slave (from the -state-file) and exit")
+
+// estimate prints an estimate of the transfers of the run: the size of the
+// uploads (the build-script, the outputs of the dependencies and the
+// FallbackScript), and that of the outputs retrieved, by the last
+// successful builds of the slaves (as recorded by the state file.)
+func estimate(w io.Writer, slaves []bldbot.Slave) error {
+	var st states
+	if *g_state_file != "" {
+		var err error
+		st, err = loadStates(*g_state_file)
+		if err != nil {
+			return fmt.Errorf("could not load state file [%s] (%v)", *g_state_file, err)
+		}
+	}
+
+	up, down := int64(0), int64(0)
+	unknown := 0
+	fmt.Fprintf(w, ">>> estimated transfers:\n")
+	for i := range slaves {
+		slave := &slaves[i]
+		history := true
+		var size string
+		fname, _, remove, err := slave.ScriptFile()
+		if err == nil {
+			var fi os.FileInfo
+			fi, err = os.Stat(fname)
+			remove()
+			if err == nil {
+				n, parts := fi.Size(), []string(nil)
+				for _, dep := range slave.DependsOn {
+					state, ok := st[dep]
+					if !ok || (state.OutputBytes <= 0 && state.Status != bldbot.StatusOK) {
+						history = false
+						parts = append(parts, fmt.Sprintf("inputs from %s: unknown", dep))
+						continue
+					}
+					n += state.OutputBytes
+					parts = append(parts, fmt.Sprintf("inputs from %s: %s", dep, bldbot.HumanSize(state.OutputBytes)))
+				}
+				if slave.FallbackScript != "" {
+					fb, ferr := os.Stat(filepath.Join(slave.Name, slave.FallbackScript))
+					if ferr != nil {
+						parts = append(parts, fmt.Sprintf("fallback script: unknown (%v)", ferr))
+					} else {
+						n += fb.Size()
+						parts = append(parts, fmt.Sprintf("fallback script: %s, if the build fails", bldbot.HumanSize(fb.Size())))
+					}
+				}
+				up += n
+				size = bldbot.HumanSize(n)
+				if len(parts) > 0 {
+					size += " (" + strings.Join(parts, "; ") + ")"
+				}
+			}
+		}
+		if err != nil {
+			size = fmt.Sprintf("unknown (%v)", err)
+		}
+
+		outputs := "unknown (no successful build recorded)"
+		state, ok := st[slave.Name]
+		if c, err := slave.OutputCollector(*g_collector); err == nil && c != nil {
+			outputs = "none (sent to the collector)"
+		} else if ok && (state.OutputBytes > 0 || state.Status == bldbot.StatusOK) {
+			down += state.OutputBytes
+			outputs = bldbot.HumanSize(state.OutputBytes)
+		} else {
+			history = false
+		}
+		if !history {
+			unknown++
+		}
+		fmt.Fprintf(w, " %s\n", slave.Label())
+		fmt.Fprintf(w, "  upload:   %s\n", size)
+		fmt.Fprintf(w, "  download: %s\n", outputs)
+	}
+
+	fmt.Fprintf(w, ">>> total: %s up, %s down", bldbot.HumanSize(up), bldbot.HumanSize(down))
+	if unknown > 0 {
+		fmt.Fprintf(w, " (+ %d slave(s) without history)", unknown)
+	}
+	fmt.Fprintf(w, "\n")
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+var events struct {
+	sync.Mutex
+	sinks []func(bldbot.Event)
+}
+
+// addEventSink registers a function called (serially) for each event.
+func addEventSink(sink func(bldbot.Event)) {
+	events.Lock()
+	defer events.Unlock()
+	events.sinks = append(events.sinks, sink)
+}
+
+// emit dispatches an event to all the registered sinks.
+func emit(ev bldbot.Event) {
+	events.Lock()
+	defer events.Unlock()
+	if len(events.sinks) <= 0 {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	for _, sink := range events.sinks {
+		sink(ev)
+	}
+}
+
+// jsonEventSink returns an event sink writing events as JSON lines on stdout.
+func jsonEventSink() func(bldbot.Event) {
+	enc := json.NewEncoder(os.Stdout)
+	return func(ev bldbot.Event) {
+		enc.Encode(ev)
+	}
+}
+
+// newRunID returns a new (unique) id for a run: <date>T<time>-<random>.
+func newRunID() string {
+	buf := make([]byte, 4)
+	rand.Read(buf)
+	return time.Now().Format("20060102T150405") + "-" + hex.EncodeToString(buf)
+}
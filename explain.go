@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+// explain writes to w the execution plan of a run over the given slaves:
+// their order and waves, the limits of the run and, for each slave, its
+// transport and the commands which would be run.
+// Nothing is run, neither locally nor on the slaves.
+func explain(w io.Writer, slaves []bldbot.Slave) {
+	slaves = append([]bldbot.Slave(nil), slaves...)
+	opts := flagOptions()
+
+	order := "as listed"
+	if *g_shuffle {
+		if *g_seed == 0 {
+			order = "random (-shuffle, no -seed)"
+		} else {
+			order = fmt.Sprintf("shuffled (seed=%d)", *g_seed)
+			shuffle(*g_seed, len(slaves), func(i, j int) {
+				slaves[i], slaves[j] = slaves[j], slaves[i]
+			})
+		}
+	}
+
+	limit := func(n int) string {
+		if n <= 0 {
+			return "unlimited"
+		}
+		return fmt.Sprint(n)
+	}
+
+	fmt.Fprintf(w, ">>> execution plan (%d slaves)\n", len(slaves))
+	fmt.Fprintf(w, "order:             %s\n", order)
+	fmt.Fprintf(w, "parallel:          %v\n", *g_parallel)
+	if *g_parallel {
+		fmt.Fprintf(w, "max-parallel:      %s\n", limit(*g_max_parallel))
+		fmt.Fprintf(w, "per-host-parallel: %s\n", limit(*g_per_host_parallel))
+	}
+	fmt.Fprintf(w, "build-retries:     %d\n", *g_build_retries)
+
+	size := *g_wave_size
+	if size <= 0 || size > len(slaves) {
+		size = len(slaves)
+	}
+	nwaves := (len(slaves) + size - 1) / size
+	if nwaves > 1 {
+		fmt.Fprintf(w, "waves:             %d of %d slaves (abort above %v%% failures)\n",
+			nwaves, size, *g_wave_fail_threshold,
+		)
+	}
+
+	setups := make(map[string]bool)
+	for i := range slaves {
+		slave := &slaves[i]
+		if nwaves > 1 && i%size == 0 {
+			fmt.Fprintf(w, "\n>>> wave %d\n", i/size+1)
+		}
+
+		base := slave.TmpBase()
+		slave.Path = path.Join(base, "go-bldbot-<date>-<random>")
+		if *g_persistent_workdir {
+			slave.Path, _ = slave.WorkDir(true)
+		}
+
+		transport := "ssh, " + *g_transport
+		if slave.IsLocal() {
+			transport = "local"
+		}
+		fmt.Fprintf(w, "\n--- slave [%s] (%s, %s)\n", slave.Label(), slave.Addr, transport)
+		if slave.Group != "" {
+			fmt.Fprintf(w, "  group:    %s\n", slave.Group)
+		}
+		if slave.Command() != "" {
+			fmt.Fprintf(w, "  command:  %s\n", slave.Command())
+		} else if slave.ScriptRef != "" {
+			repo := slave.ScriptRepo
+			if repo == "" {
+				repo = "."
+			}
+			fmt.Fprintf(w, "  script:   %s (at %s in git repo %s)\n", slave.LocalCommandFileName(), slave.ScriptRef, repo)
+		} else {
+			fmt.Fprintf(w, "  script:   %s\n", slave.LocalCommandFileName())
+		}
+		if slave.FallbackScript != "" {
+			fmt.Fprintf(w, "  fallback: %s (if the build fails)\n", filepath.Join(slave.Name, slave.FallbackScript))
+		}
+		fmt.Fprintf(w, "  workdir:  %s\n", slave.Path)
+		if slave.Setup != "" && !setups[slave.Addr] {
+			setups[slave.Addr] = true
+			fmt.Fprintf(w, "  setup:    %s\n", slave.Setup)
+		}
+		if slave.Condition != "" {
+			fmt.Fprintf(w, "  only if:  %s\n", slave.Condition)
+		}
+		if slave.WaitFor != "" {
+			fmt.Fprintf(w, "  wait for: %s\n", slave.WaitFor)
+		}
+		fmt.Fprintf(w, "  build:    %s\n", slave.BuildCommand(opts))
+		if len(slave.SecretEnv) > 0 {
+			fmt.Fprintf(w, "  secrets:  %s (on stdin, redacted)\n", strings.Join(slave.SecretEnv, " "))
+		}
+		if max, err := slave.BuildDurationLimit(opts.MaxBuildDuration); err != nil {
+			fmt.Fprintf(w, "  max time: invalid (%v)\n", err)
+		} else if max > 0 {
+			fmt.Fprintf(w, "  max time: %v\n", max)
+		}
+		if timeout, clamped, err := slave.TimeoutWithin(*g_timeout, *g_max_timeout); err != nil {
+			fmt.Fprintf(w, "  timeout:  invalid (%v)\n", err)
+		} else if clamped {
+			fmt.Fprintf(w, "  timeout:  %v (clamped from %s)\n", timeout, slave.RequestedTimeout(opts.DefaultTimeout))
+		} else if timeout > 0 {
+			fmt.Fprintf(w, "  timeout:  %v\n", timeout)
+		}
+		if len(slave.DependsOn) > 0 {
+			fmt.Fprintf(w, "  depends:  %s (outputs into %s/)\n", strings.Join(slave.DependsOn, ", "), bldbot.InputsDir)
+		}
+		for _, target := range slave.Targets {
+			optional := ""
+			if target.Optional {
+				optional = " (optional)"
+			}
+			fmt.Fprintf(w, "  target:   [%s] %s%s\n", target.Name, target.Command, optional)
+		}
+		for _, post := range slave.PostBuild {
+			fmt.Fprintf(w, "  post:     %s\n", post)
+		}
+		for _, image := range slave.PushImage {
+			fmt.Fprintf(w, "  push:     %s\n", slave.PushRef(image))
+		}
+		if slave.SBOMCommand != "" {
+			fmt.Fprintf(w, "  sbom:     %s (into %s)\n", slave.SBOMCommand, slave.SBOMName())
+		} else if *g_sbom_tool != "" {
+			fmt.Fprintf(w, "  sbom:     %s <outputs> (into %s)\n", *g_sbom_tool, slave.SBOMName())
+		}
+		if len(slave.RetryExitCodes) > 0 && *g_build_retries > 0 {
+			fmt.Fprintf(w, "  retry on: exit codes %v\n", slave.RetryExitCodes)
+		}
+		if len(slave.RemoteLogs) > 0 {
+			fmt.Fprintf(w, "  logs:     %s\n", strings.Join(slave.RemoteLogs, " "))
+		}
+		odir := slave.OutputDir
+		if odir == "" {
+			odir = "output"
+		}
+		if slave.FindExpr != "" {
+			fmt.Fprintf(w, "  outputs:  find %s %s\n", path.Join(slave.Path, odir), slave.FindExpr)
+		} else {
+			fmt.Fprintf(w, "  outputs:  %s/*.tar.gz\n", path.Join(slave.Path, odir))
+		}
+		if c, err := slave.OutputCollector(*g_collector); err != nil {
+			fmt.Fprintf(w, "  collect:  invalid (%v)\n", err)
+		} else if c != nil {
+			fmt.Fprintf(w, "  collect:  %s:%s\n", c.Addr, path.Join(c.Path, slave.Name))
+		}
+	}
+}
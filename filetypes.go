@@ -0,0 +1,7 @@
+package main
+
+import (
+	"flag"
+)
+
+var g_verify_types = flag.Bool("verify-types", false, "check that the retrieved outputs are of the type expected from their extension (or OutputTypes), failing the build otherwise")
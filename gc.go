@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+// workDirRe matches the names of the work directories created by newWorkDir.
+var workDirRe = regexp.MustCompile(`^go-bldbot-[0-9]{8}-[0-9a-f]{16}$`)
+
+// runGC implements the gc subcommand: it removes the work directories left
+// over on the slaves by crashed or interrupted runs, and returns the exit
+// code of the program.
+func runGC(slaves []bldbot.Slave, args []string) int {
+	fset := flag.NewFlagSet("gc", flag.ExitOnError)
+	age := fset.Duration("age", 24*time.Hour, "only remove the work directories older than that")
+	dryrun := fset.Bool("dry-run", false, "only report the work directories which would be removed")
+	fset.Parse(args)
+
+	if *g_lock_file != "" {
+		lock, err := acquireLock(*g_lock_file, *g_lock_wait, *g_lock_max_age)
+		if err != nil {
+			log.Printf("buildbot: %v\n", err)
+			return 2
+		}
+		defer lock.release()
+	}
+
+	code := 0
+	total := int64(0)
+	seen := make(map[string]bool)
+	for i := range slaves {
+		slave := &slaves[i]
+		base := slave.TmpBase()
+		base = path.Clean(base)
+		key := slave.Addr + ":" + base
+		if slave.IsLocal() {
+			key = "local:" + base
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		n, err := gcSlave(slave, base, *age, *dryrun)
+		total += n
+		if err != nil {
+			log.Printf("gc: slave [%s]: %v\n", slave.Name, err)
+			code = 1
+		}
+	}
+
+	verb := "reclaimed"
+	if *dryrun {
+		verb = "would reclaim"
+	}
+	summaryf(">>> gc: %s %s\n", verb, bldbot.HumanSize(total))
+	return code
+}
+
+// gcSlave removes the work directories older than age from the remote
+// directory base of the slave, and returns the space (in bytes) reclaimed.
+func gcSlave(slave *bldbot.Slave, base string, age time.Duration, dryrun bool) (int64, error) {
+	err := bldbot.CheckRemotePath(path.Join(base, "go-bldbot-x"))
+	if err != nil {
+		return 0, err
+	}
+	kept := detachedPaths()
+	ctx := context.Background()
+	t := bldbot.NewTransport(slave, flagOptions())
+	out := new(bytes.Buffer)
+	errs := new(bytes.Buffer)
+	cmd := fmt.Sprintf(
+		"find %s -mindepth 1 -maxdepth 1 -type d -name 'go-bldbot-*' -mmin +%d -exec du -sk {} \\;",
+		bldbot.ShellQuote(base), int(age.Minutes()),
+	)
+	err = t.Run(ctx, cmd, nil, out, errs)
+	if err != nil {
+		return 0, fmt.Errorf("could not list work directories (%v: %s)", err, strings.TrimSpace(errs.String()))
+	}
+
+	total := int64(0)
+	for _, line := range strings.Split(out.String(), "\n") {
+		f := strings.SplitN(strings.TrimSpace(line), "\t", 2)
+		if len(f) != 2 {
+			continue
+		}
+		dir := f[1]
+		// only touch what is recognizably ours.
+		if path.Dir(dir) != base || !workDirRe.MatchString(path.Base(dir)) || bldbot.CheckRemotePath(dir) != nil {
+			continue
+		}
+		if kept[slave.Addr+":"+dir] {
+			// a detached build, not collected yet.
+			continue
+		}
+		kb, err := strconv.ParseInt(f[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if dryrun {
+			progressf(" %s: would remove [%s] (%s)\n", slave.Name, dir, bldbot.HumanSize(kb*1024))
+			total += kb * 1024
+			continue
+		}
+		errs.Reset()
+		err = t.Remove(ctx, dir, errs)
+		if err != nil {
+			return total, fmt.Errorf("could not remove [%s] (%v: %s)", dir, err, strings.TrimSpace(errs.String()))
+		}
+		progressf(" %s: removed [%s] (%s)\n", slave.Name, dir, bldbot.HumanSize(kb*1024))
+		total += kb * 1024
+	}
+	return total, nil
+}
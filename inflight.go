@@ -0,0 +1,7 @@
+package main
+
+import (
+	"flag"
+)
+
+var g_max_inflight_bytes = flag.Int64("max-inflight-bytes", 0, "maximum total size (in bytes) of the outputs being retrieved at the same time (0: unlimited)")
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+var g_ledger = flag.String("ledger", "", "append-only (JSON lines) file where each build is recorded, with the hashes of its build-script and outputs, chained to the previous entry")
+
+// ledgerEntry is the record of a build in the ledger (-ledger.)
+// Inputs maps the outputs of the dependencies (DependsOn) the build was
+// given, as <slave>/<file> under its inputs directory, to their SHA-256.
+// Prev is the SHA-256 of the previous line of the ledger (empty for the
+// first one), so that any edit of an entry breaks the chain of the next.
+type ledgerEntry struct {
+	Time         time.Time             `json:"time"`
+	Label        string                `json:"label,omitempty"`
+	Slave        string                `json:"slave"`
+	Addr         string                `json:"addr"`
+	Status       string                `json:"status"`
+	Script       string                `json:"script"`
+	ScriptSHA256 string                `json:"script_sha256,omitempty"`
+	ScriptRev    string                `json:"script_rev,omitempty"`
+	Artifacts    []bldbot.ArtifactInfo `json:"artifacts,omitempty"`
+	Inputs       map[string]string     `json:"inputs,omitempty"`
+	Prev         string                `json:"prev"`
+}
+
+// appendLedger appends an entry per build (not run because of its slave
+// being unreachable or the run aborted, nor still running detached) to the
+// ledger fname.
+func appendLedger(fname string, reports []bldbot.BuildReport) error {
+	prev, err := ledgerHead(fname)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, report := range reports {
+		switch report.Status() {
+		case bldbot.StatusUnreachable, bldbot.StatusAborted, bldbot.StatusDetached:
+			continue
+		}
+		entry := ledgerEntry{
+			Time:      report.Built,
+			Label:     *g_run_label,
+			Slave:     report.Slave.Name,
+			Addr:      report.Slave.Addr,
+			Status:    report.Status(),
+			Script:    report.Slave.LocalCommandFileName(),
+			ScriptRev: report.ScriptRev,
+			Artifacts: report.Artifacts,
+			Prev:      prev,
+		}
+		if entry.Time.IsZero() {
+			entry.Time = now
+		}
+		for dep, files := range report.Inputs {
+			for _, fname := range files {
+				sum, _, err := bldbot.HashFile(fname)
+				if err != nil {
+					f.Close()
+					return err
+				}
+				if entry.Inputs == nil {
+					entry.Inputs = make(map[string]string)
+				}
+				entry.Inputs[path.Join(dep, filepath.Base(fname))] = sum
+			}
+		}
+		if report.Slave.ScriptRef != "" {
+			entry.Script = report.Slave.ScriptRef + ":" + entry.Script
+		} else if sum, _, err := bldbot.HashFile(entry.Script); err == nil {
+			entry.ScriptSHA256 = sum
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		_, err = f.Write(append(line, '\n'))
+		if err != nil {
+			f.Close()
+			return err
+		}
+		prev = ledgerHash(line)
+	}
+	return f.Close()
+}
+
+// ledgerHead returns the hash of the last entry of the ledger fname ("" if
+// it is empty or does not exist.)
+func ledgerHead(fname string) (string, error) {
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	buf = bytes.TrimRight(buf, "\n")
+	if len(buf) <= 0 {
+		return "", nil
+	}
+	if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+		buf = buf[i+1:]
+	}
+	return ledgerHash(buf), nil
+}
+
+func ledgerHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
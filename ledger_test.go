@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+func TestAppendLedgerInputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-bldbot-ledger-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "lib.tar.gz")
+	err = ioutil.WriteFile(input, []byte("lib"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, _, err := bldbot.HashFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fname := filepath.Join(dir, "ledger.jsonl")
+	reports := []bldbot.BuildReport{
+		{Slave: bldbot.Slave{Name: "lib"}},
+		{
+			Slave:  bldbot.Slave{Name: "app", DependsOn: []string{"lib"}},
+			Inputs: map[string][]string{"lib": {input}},
+		},
+	}
+	err = appendLedger(fname, reports)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []ledgerEntry
+	var lines [][]byte
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		var entry ledgerEntry
+		err = json.Unmarshal(scan.Bytes(), &entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+		lines = append(lines, append([]byte(nil), scan.Bytes()...))
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if entries[0].Inputs != nil {
+		t.Errorf("entry of [lib] has inputs %v, want none", entries[0].Inputs)
+	}
+	if got := entries[1].Inputs["lib/lib.tar.gz"]; got != sum || len(entries[1].Inputs) != 1 {
+		t.Errorf("entry of [app] has inputs %v, want lib/lib.tar.gz: %s", entries[1].Inputs, sum)
+	}
+	if entries[1].Prev != ledgerHash(lines[0]) {
+		t.Errorf("entry of [app] not chained to that of [lib]")
+	}
+}
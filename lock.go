@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var g_lock_file = flag.String("lock-file", "", "lock file preventing concurrent runs against the same fleet")
+var g_lock_wait = flag.Duration("lock-wait", 0, "how long to wait for the -lock-file to be released (0: fail fast)")
+var g_lock_max_age = flag.Duration("lock-max-age", 24*time.Hour, "age after which a -lock-file is deemed stale and reclaimed")
+
+// lockPoll is the period at which a busy lock file is tried again.
+const lockPoll = time.Second
+
+// fleetLock is a lock file holding the host, PID and start time of the run
+// owning it.
+type fleetLock struct {
+	fname string
+	once  sync.Once
+}
+
+// acquireLock creates the lock file fname, waiting up to wait for a
+// concurrent run to release it. A lock left by a dead process or older than
+// maxAge is reclaimed.
+func acquireLock(fname string, wait, maxAge time.Duration) (*fleetLock, error) {
+	host, _ := os.Hostname()
+	content := fmt.Sprintf("%s %d %d\n", host, os.Getpid(), time.Now().Unix())
+	deadline := time.Now().Add(wait)
+	for {
+		f, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.WriteString(content)
+			if err == nil {
+				err = f.Close()
+			} else {
+				f.Close()
+			}
+			if err != nil {
+				os.Remove(fname)
+				return nil, err
+			}
+			return &fleetLock{fname: fname}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		owner, held, stale := staleLock(fname, host, maxAge)
+		if stale {
+			if held != nil && reclaimLock(fname, held) {
+				log.Printf("buildbot: reclaimed stale lock [%s] (%s)\n", fname, owner)
+			}
+			continue
+		}
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("fleet is busy (lock [%s] held by %s)", fname, owner)
+		}
+		progressf(">>> fleet is busy (lock [%s] held by %s), waiting...\n", fname, owner)
+		time.Sleep(lockPoll)
+	}
+}
+
+// staleLock returns the owner of the lock file fname, its content and
+// whether that lock is stale: its process (on the local host) is dead, or it
+// is older than maxAge (going by the modification time of the file when its
+// content cannot be parsed.)
+func staleLock(fname, host string, maxAge time.Duration) (string, []byte, bool) {
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		// released in the meantime: try again.
+		return "nobody", nil, os.IsNotExist(err)
+	}
+	f := strings.Fields(string(buf))
+	var pid int
+	var stamp int64
+	err = fmt.Errorf("not 'host pid stamp'")
+	if len(f) == 3 {
+		pid, err = strconv.Atoi(f[1])
+		if err == nil {
+			stamp, err = strconv.ParseInt(f[2], 10, 64)
+		}
+	}
+	if err != nil {
+		// e.g. empty, its run having crashed before writing it: only its
+		// age tells.
+		owner := fmt.Sprintf("%q", strings.TrimSpace(string(buf)))
+		fi, err := os.Stat(fname)
+		if err != nil {
+			return "nobody", nil, os.IsNotExist(err)
+		}
+		return owner, buf, maxAge > 0 && time.Since(fi.ModTime()) > maxAge
+	}
+	start := time.Unix(stamp, 0)
+	owner := fmt.Sprintf("pid %d on %s since %v", pid, f[0], start.Format(time.RFC3339))
+	if maxAge > 0 && time.Since(start) > maxAge {
+		return owner, buf, true
+	}
+	if f[0] == host && !processAlive(pid) {
+		return owner, buf, true
+	}
+	return owner, buf, false
+}
+
+// reclaimLock removes the stale lock file fname, whose content was stale,
+// and returns whether it did.
+// The lock file is first moved aside, so that only one of the runs
+// reclaiming it at once gets it: if it no longer holds stale, a concurrent
+// run reclaimed it first and took the lock, which is then put back.
+func reclaimLock(fname string, stale []byte) bool {
+	tmp := fmt.Sprintf("%s.%d-%d", fname, os.Getpid(), time.Now().UnixNano())
+	err := os.Rename(fname, tmp)
+	if err != nil {
+		// released or reclaimed in the meantime.
+		return false
+	}
+	defer os.Remove(tmp)
+	buf, err := ioutil.ReadFile(tmp)
+	if err == nil && bytes.Equal(buf, stale) {
+		return true
+	}
+	// os.Link fails if a lock was created in the meantime, where os.Rename
+	// would replace it.
+	err = os.Link(tmp, fname)
+	if err != nil {
+		log.Printf("buildbot: could not restore lock [%s] (%v)\n", fname, err)
+	}
+	return false
+}
+
+// release removes the lock file, once: releasing it again (e.g. from the
+// -tui interrupt handler as well as main) does nothing.
+func (l *fleetLock) release() {
+	if l == nil {
+		return
+	}
+	l.once.Do(func() {
+		err := os.Remove(l.fname)
+		if err != nil {
+			log.Printf("buildbot: could not remove lock [%s] (%v)\n", l.fname, err)
+		}
+	})
+}
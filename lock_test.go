@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReclaimLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-bldbot-lock-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "fleet.lock")
+
+	stale := []byte("host 1 1\n")
+	err = ioutil.WriteFile(fname, stale, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reclaimLock(fname, stale) {
+		t.Fatalf("stale lock not reclaimed")
+	}
+	if _, err := os.Stat(fname); !os.IsNotExist(err) {
+		t.Fatalf("stale lock still there (err=%v)", err)
+	}
+
+	// a concurrent run reclaimed the lock first, and holds it.
+	held := []byte("host 2 2\n")
+	err = ioutil.WriteFile(fname, held, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimLock(fname, stale) {
+		t.Fatalf("lock of a concurrent run reclaimed")
+	}
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil || string(buf) != string(held) {
+		t.Fatalf("lock of a concurrent run not restored: %q (err=%v)", buf, err)
+	}
+	if names, _ := filepath.Glob(fname + ".*"); len(names) != 0 {
+		t.Fatalf("left over files: %v", names)
+	}
+}
+
+func TestStaleLockUnparsable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-bldbot-lock-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "fleet.lock")
+
+	for _, content := range []string{"", "host", "host 12", "host 12 17x"} {
+		err = ioutil.WriteFile(fname, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, stale := staleLock(fname, "host", time.Hour); stale {
+			t.Errorf("fresh lock %q deemed stale", content)
+		}
+		old := time.Now().Add(-2 * time.Hour)
+		err = os.Chtimes(fname, old, old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, stale := staleLock(fname, "host", time.Hour); !stale {
+			t.Errorf("lock %q older than -lock-max-age not deemed stale", content)
+		}
+		if _, _, stale := staleLock(fname, "host", 0); stale {
+			t.Errorf("lock %q deemed stale without -lock-max-age", content)
+		}
+	}
+}
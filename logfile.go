@@ -0,0 +1,7 @@
+package main
+
+import (
+	"flag"
+)
+
+var g_timestamp_logs = flag.Bool("timestamp-logs", false, "prefix each line of the logfiles with the (ISO 8601) time it started to be written")
@@ -5,310 +5,986 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	mrand "math/rand"
+	"net"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	yml "github.com/gonuts/yaml"
+	"github.com/gogenesis/go-bldbot/bldbot"
 )
 
-var g_config = flag.String("config", "config.yaml", "(YAML) file containing the list of slaves")
+var g_config = flag.String("config", "config.yaml", "comma-separated list of (YAML or JSON) files or globs containing the list of slaves")
+var g_defaults = flag.String("defaults", "", "(TOML) file containing defaults for the command line flags, one 'flag = value' per line")
 var g_parallel = flag.Bool("parallel", true, "run the build-slaves in parallel")
+var g_max_parallel = flag.Int("max-parallel", 0, "maximum number of builds running in parallel (0: no limit)")
+var g_per_host_parallel = flag.Int("per-host-parallel", 0, "maximum number of builds running in parallel on a host (0: no limit)")
+var g_build_retries = flag.Int("build-retries", 0, "number of times a failed build is re-run from scratch")
+var g_split_logs = flag.Bool("split-logs", false, "write the output of each build phase into its own logfile")
+var g_compress_upload = flag.Bool("compress-upload", false, "gzip the build-script on the wire while uploading it")
+var g_control_addr = flag.String("control-addr", "", "address of the HTTP control endpoint (e.g. localhost:8080)")
+var g_require_all = flag.Bool("require-all", false, "fail the run if any slave is unreachable")
+var g_report_json = flag.String("report-json", "", "file where to write the (JSON) reports of all slaves")
+var g_consolidated_log = flag.String("consolidated-log", "", "file where to write the logs of all slaves, grouped by slave")
+var g_shellcheck = flag.String("shellcheck", "", "run shellcheck on the build-scripts and 'warn' or 'fail' on findings")
+var g_upload_s3 = flag.String("upload-s3", "", "S3 location (bucket/prefix) where to upload the retrieved outputs")
+var g_upload_required = flag.Bool("upload-required", false, "fail a build whose outputs could not be uploaded (see -upload-s3)")
+var g_watch = flag.Bool("watch", false, "watch the build-scripts directories (with inotify on Linux, else polled every 500ms) and rebuild the slaves on changes, holding the -lock-file during the rebuilds only")
+var g_progress = flag.Bool("progress", false, "report the progress of the outputs retrieval (requires rsync)")
+var g_sign_key = flag.String("sign-key", "", "GPG key used to (locally) sign the retrieved outputs")
+var g_shuffle = flag.Bool("shuffle", false, "randomize the order in which the builds are started")
+var g_seed = flag.Int64("seed", 0, "seed used by -shuffle (0: random)")
+var g_tail_lines = flag.Int("tail-lines", 0, "number of last lines of the build output shown in the summary and reports")
+var g_max_log_size = flag.Int64("max-log-size", 0, "maximum size (in bytes) of a logfile, keeping its head and tail (0: unlimited)")
+var g_allowed_hosts = flag.String("allowed-hosts", "", "file listing the only slave addresses builds may be sent to")
+var g_wave_size = flag.Int("wave-size", 0, "run the builds in waves of that many slaves (0: a single wave)")
+var g_wave_fail_threshold = flag.Float64("wave-fail-threshold", 100, "abort the run when the failure rate (%) of a wave exceeds that threshold")
+var g_events_json = flag.Bool("events-json", false, "write the orchestration events as JSON lines on stdout")
+var g_nice = flag.Int("nice", 0, "niceness of the remote builds (0: unchanged)")
+var g_ionice = flag.Int("ionice", 0, "I/O scheduling class of the remote builds, e.g. 3 for idle (0: unchanged)")
+var g_quiet countFlag
+var g_fail_patterns regexpsFlag
+var g_explain = flag.Bool("explain", false, "print the execution plan of the run and exit, without contacting any slave")
+var g_tui = flag.Bool("tui", false, "show a live dashboard of the builds in the terminal")
+var g_sysinfo = flag.Bool("sysinfo", false, "capture the system info (uname, CPUs, memory, toolchains) of the slaves into the reports")
+var g_sysinfo_cmds mapFlag
+var g_max_build_duration = flag.Duration("max-build-duration", 0, "fail a build whose build-script ran longer than that (0: no limit)")
+var g_login_shell = flag.Bool("login-shell", false, "run the build-scripts under a (bash) login shell, sourcing the login environment of the slaves")
+var g_command_hook = flag.String("command-hook", "", "program rewriting the command lines run for the slaves (JSON operation on stdin, JSON argv on stdout)")
+var g_no_time = flag.Bool("no-time", false, "run the build-scripts directly, not under the (remote) time command")
+var g_persistent_workdir = flag.Bool("persistent-workdir", false, "reuse the same work directory on each slave across builds and runs")
+var g_preflight = flag.Bool("preflight", true, "check the local setup (programs, build-scripts, directories) before any remote work")
+var g_stagger = flag.Duration("stagger", 0, "delay each build by a random duration, up to that, before running its build-script")
+var g_manifest_out = flag.String("manifest-out", "", "file where to write the (JSON) manifest of all the outputs retrieved by the run")
+var g_timeout = flag.Duration("timeout", 0, "kill a build-script still running after that long (0: no timeout)")
+var g_max_timeout = flag.Duration("max-timeout", 0, "ceiling on the timeout of any build-script, -timeout and Timeout of slaves alike (0: no ceiling)")
+var g_run_label = flag.String("run-label", "", "label of the run (e.g. nightly), recorded in the reports and added to their file names")
+var g_retrieve_only = flag.String("retrieve-only", "", "comma-separated list of slaves (or * for all) whose outputs are retrieved again from their -persistent-workdir, without building")
+var g_fail_on_stderr = flag.Bool("fail-on-stderr", false, "fail a build whose build-script wrote anything on its stderr")
+var g_transfer_retries = flag.Int("transfer-retries", 0, "number of times a failed copy to/from a slave (upload, retrieval) is retried, apart from -build-retries")
+var g_allow_partial_outputs = flag.Bool("allow-partial-outputs", false, "do not fail a build when only some of its outputs could not be retrieved (they are reported)")
+var g_banner = flag.String("banner", "buildbot", "text of the banner printed when starting (empty: no banner)")
+var g_require_outputs = flag.Bool("require-outputs", false, "fail a build which did not produce any output")
+
+func init() {
+	flag.Var(&g_quiet, "quiet", "suppress progress messages (twice: also the summary of successful runs)")
+	flag.Var(&g_fail_patterns, "fail-on-pattern", "fail a build whose output matches that regexp (may be repeated)")
+	flag.Var(&g_sysinfo_cmds, "sysinfo-command", "name=command run on the slaves with -sysinfo (may be repeated, an empty command removes a default)")
+}
+
+// countFlag is a boolean-like flag counting how many times it was given.
+type countFlag int
 
-type Config struct {
-	Slaves []Slave
+func (c *countFlag) String() string {
+	return strconv.Itoa(int(*c))
 }
 
-type Slave struct {
-	Addr string // slave SSH address
-	Name string // informative name of that slave
-	Path string // path under which all build files and artifacts are stored
+func (c *countFlag) Set(s string) error {
+	switch s {
+	case "true":
+		*c++
+		return nil
+	case "false":
+		*c = 0
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*c = countFlag(n)
+	return nil
 }
 
-func (s *Slave) LocalCommandFileName() string {
-	return filepath.Join(s.Name, "build.sh")
+func (c *countFlag) IsBoolFlag() bool { return true }
+
+// progressf prints a progress message on stdout, unless running with -quiet.
+func progressf(format string, args ...interface{}) {
+	if g_quiet > 0 {
+		return
+	}
+	fmt.Printf(format, args...)
 }
 
-func (s *Slave) RemoteCommandFileName() string {
-	return filepath.Join(s.Path, "build.sh")
+// summaryf prints a line of the summary of the run on stdout, unless
+// running with -quiet -quiet.
+func summaryf(format string, args ...interface{}) {
+	if g_quiet > 1 {
+		return
+	}
+	fmt.Printf(format, args...)
 }
 
-func (s *Slave) Ping() error {
-	var err error
-	ssh := exec.Command(
-		"ssh",
-		s.Addr,
-		"echo hello",
-	)
-	out, err := ssh.CombinedOutput()
+// selectSlaves returns the slaves named in the comma-separated list names
+// (* for all), in their order.
+func selectSlaves(slaves []bldbot.Slave, names string) ([]bldbot.Slave, error) {
+	if strings.TrimSpace(names) == "*" {
+		return slaves, nil
+	}
+	want := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			want[name] = true
+		}
+	}
+	selected := make([]bldbot.Slave, 0, len(want))
+	for _, slave := range slaves {
+		if want[slave.Name] {
+			selected = append(selected, slave)
+			delete(want, slave.Name)
+		}
+	}
+	for name := range want {
+		return nil, fmt.Errorf("no such slave [%s]", name)
+	}
+	return selected, nil
+}
+
+// checkAllowedHosts checks that the addresses of all the slaves are listed
+// in the allowlist file fname (one address per line, '#' starts a comment.)
+func checkAllowedHosts(slaves []bldbot.Slave, fname string) error {
+	buf, err := ioutil.ReadFile(fname)
 	if err != nil {
-		return fmt.Errorf(
-			"slave [%s] did not respond (%v: %s)",
-			s.Name, err, string(out),
-		)
+		return fmt.Errorf("could not read allowed hosts [%s] (%v)", fname, err)
+	}
+	allowed := make(map[string]bool)
+	for _, line := range strings.Split(string(buf), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line != "" {
+			allowed[line] = true
+		}
+	}
+
+	refused := make([]string, 0)
+	for _, slave := range slaves {
+		if !allowed[slave.Addr] {
+			refused = append(refused, fmt.Sprintf("%s (%s)", slave.Name, slave.Addr))
+		}
+	}
+	if len(refused) > 0 {
+		return fmt.Errorf("slaves not in allowed hosts [%s]: %s", fname, strings.Join(refused, ", "))
+	}
+	return nil
+}
+
+// shellcheck runs shellcheck on the (local) build-scripts of the slaves.
+// In "fail" mode, findings are reported as an error. In "warn" mode, they
+// are only logged.
+// If shellcheck is not installed, the check is (noisily) skipped.
+func shellcheck(slaves []bldbot.Slave, mode string) error {
+	if mode != "warn" && mode != "fail" {
+		return fmt.Errorf("invalid -shellcheck mode [%s] (want 'warn' or 'fail')", mode)
+	}
+	exe, err := exec.LookPath("shellcheck")
+	if err != nil {
+		log.Printf("shellcheck not found, skipping check of build-scripts\n")
+		return nil
+	}
+
+	bad := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, slave := range slaves {
+		fname := slave.LocalCommandFileName()
+		if seen[fname] {
+			continue
+		}
+		seen[fname] = true
+		if _, err := os.Stat(fname); err != nil {
+			// reported later on, by the builder.
+			continue
+		}
+		out, err := exec.Command(exe, fname).CombinedOutput()
+		if err != nil {
+			log.Printf("shellcheck findings for [%s]:\n%s\n", fname, string(out))
+			bad = append(bad, fname)
+		}
 	}
-	return err
+	if len(bad) > 0 && mode == "fail" {
+		return fmt.Errorf("shellcheck failed for %v", bad)
+	}
+	return nil
 }
 
-type BuildReport struct {
-	slave Slave
-	msg   string
-	err   error
+// limiter bounds the number of builds running in parallel, overall and per
+// host.
+type limiter struct {
+	mu      sync.Mutex
+	all     chan struct{} // nil if not limited
+	perHost int
+	hosts   map[string]chan struct{}
 }
 
-type Builder struct {
-	slave Slave
-	w     *os.File // logfile
+func newLimiter(max, perHost int) *limiter {
+	l := &limiter{
+		perHost: perHost,
+		hosts:   make(map[string]chan struct{}),
+	}
+	if max > 0 {
+		l.all = make(chan struct{}, max)
+	}
+	return l
 }
 
-func (b Builder) run() BuildReport {
-	fmt.Fprintf(b.w, "## build -- start [%v]\n", time.Now())
-	fname := b.slave.LocalCommandFileName()
-	f, err := os.Open(fname)
-	if err != nil {
-		log.Printf(
-			"no such file [%s] for slave [%s] (%v)\n",
-			fname, b.slave.Addr, err,
-		)
-		return BuildReport{
-			b.slave,
-			fmt.Sprintf("no such file [%s] (err=%v)", fname, err),
-			err,
+// acquire blocks until a build may start on the host addr and returns the
+// function to call once that build is done.
+func (l *limiter) acquire(addr string) func() {
+	var host chan struct{}
+	if l.perHost > 0 {
+		l.mu.Lock()
+		host = l.hosts[addr]
+		if host == nil {
+			host = make(chan struct{}, l.perHost)
+			l.hosts[addr] = host
 		}
+		l.mu.Unlock()
+		host <- struct{}{}
+	}
+	if l.all != nil {
+		l.all <- struct{}{}
 	}
-	defer f.Close()
+	return func() {
+		if l.all != nil {
+			<-l.all
+		}
+		if host != nil {
+			<-host
+		}
+	}
+}
 
-	{
-		ssh := exec.Command(
-			"ssh",
-			b.slave.Addr,
-			fmt.Sprintf("mkdir -p %s", b.slave.Path),
-		)
-		ssh.Stdout = b.w
-		ssh.Stderr = b.w
-		err = ssh.Run()
-		if err != nil {
-			// log.Printf("failed to copy [%s] to slave [%s] (err=%v)\ncmd=%v\n",
-			// 	fname, b.slave.Name, err, ssh.Args,
-			// )
-			return BuildReport{
-				b.slave,
-				"failed to copy [" + fname + "]",
-				err,
+// shuffle randomizes the order of n elements (swapped with swap) from seed.
+func shuffle(seed int64, n int, swap func(i, j int)) {
+	mrand.New(mrand.NewSource(seed)).Shuffle(n, swap)
+}
+
+// splitWaves splits the builders into waves of (at most) size builders.
+// A size of 0 yields a single wave.
+func splitWaves(builders []*bldbot.Builder, size int) [][]*bldbot.Builder {
+	if size <= 0 || size >= len(builders) {
+		return [][]*bldbot.Builder{builders}
+	}
+	waves := make([][]*bldbot.Builder, 0, (len(builders)+size-1)/size)
+	for len(builders) > size {
+		waves = append(waves, builders[:size])
+		builders = builders[size:]
+	}
+	return append(waves, builders)
+}
+
+// launch runs the builds of the given builders (in parallel with -parallel)
+// and returns their reports, once they are all done.
+func launch(builders []*bldbot.Builder, limits *limiter) []bldbot.BuildReport {
+	names := make([]string, 0, len(builders))
+	for _, builder := range builders {
+		names = append(names, builder.Slave().Name)
+	}
+	progressf(">>> launching builders %v... (parallel=%v)\n", names, *g_parallel)
+
+	reports := make([]bldbot.BuildReport, 0, len(builders))
+	done := make(chan bldbot.BuildReport)
+	var rescheduled []*bldbot.Builder // (-parallel=false)
+	for _, builder := range builders {
+		progressf(" %s...\n", builder.Slave().Name)
+		if *g_parallel {
+			go func(builder *bldbot.Builder) {
+				for n := 0; ; n++ {
+					release := limits.acquire(builder.Slave().Addr)
+					report := builder.Run()
+					release()
+					if !builder.Reschedule(report, n) {
+						report.Rescheduled = n
+						done <- report
+						return
+					}
+					builder.Wait()
+				}
+			}(builder)
+		} else {
+			resp := builder.Run()
+			if builder.Reschedule(resp, 0) {
+				// run it again once the other builds are done.
+				rescheduled = append(rescheduled, builder)
+				continue
+			}
+			reports = append(reports, resp)
+			summarize(resp)
+		}
+	}
+	for n := 1; len(rescheduled) > 0; n++ {
+		queue := rescheduled
+		rescheduled = nil
+		for _, builder := range queue {
+			builder.Wait()
+			resp := builder.Run()
+			if builder.Reschedule(resp, n) {
+				rescheduled = append(rescheduled, builder)
+				continue
 			}
+			resp.Rescheduled = n
+			reports = append(reports, resp)
+			summarize(resp)
 		}
 	}
+	progressf(">>> launching builders... (parallel=%v) [done]\n", *g_parallel)
 
-	ssh := exec.Command(
-		"scp", fname,
-		fmt.Sprintf("%s:%s", b.slave.Addr, b.slave.RemoteCommandFileName()),
-	)
+	if *g_parallel {
+		for _ = range builders {
+			report := <-done
+			reports = append(reports, report)
+			summarize(report)
+		}
+	}
+	return reports
+}
 
-	fmt.Fprintf(b.w, "## build -- copying build-script...\n")
-	b.w.Sync()
-	ssh.Stdout = b.w
-	ssh.Stderr = b.w
-	err = ssh.Run()
-	if err != nil {
-		// log.Printf("failed to copy [%s] to slave [%s] (err=%v)\ncmd=%v\n",
-		// 	fname, b.slave.Name, err, ssh.Args,
-		// )
-		return BuildReport{
-			b.slave,
-			"failed to copy [" + fname + "]",
-			err,
-		}
-	}
-
-	ssh = exec.Command(
-		"ssh",
-		b.slave.Addr,
-		fmt.Sprintf(
-			"time %s %s",
-			b.slave.RemoteCommandFileName(),
-			b.slave.Path,
-		),
-	)
-	fmt.Fprintf(b.w, "## build -- running build-script...\n")
-	b.w.Sync()
-	ssh.Stdout = b.w
-	ssh.Stderr = b.w
-	err = ssh.Run()
-	if err != nil {
-		// log.Printf("build failed for slave [%s] (err=%v)\n",
-		// 	b.slave.Name, err,
-		// )
-		return BuildReport{
-			b.slave,
-			"build failed",
-			err,
-		}
-	}
-
-	// retrieve output
-	ssh = exec.Command(
-		"scp",
-		fmt.Sprintf("%s:%s/output/*.tar.gz", b.slave.Addr, b.slave.Path), // */ dumb emacs
-		"output/.",
-	)
-	fmt.Fprintf(b.w, "## build -- retrieving output(s)...\n")
-	b.w.Sync()
-	ssh.Stdout = b.w
-	ssh.Stderr = b.w
-	err = ssh.Run()
-	b.w.Sync()
-	b.w.Close()
+// groupSlaves returns the groups of the slaves (in order of appearance)
+// and, for each group, the names of its slaves.
+func groupSlaves(slaves []bldbot.Slave) ([]string, map[string]map[string]bool) {
+	groups := make([]string, 0)
+	members := make(map[string]map[string]bool)
+	for _, slave := range slaves {
+		if members[slave.Group] == nil {
+			groups = append(groups, slave.Group)
+			members[slave.Group] = make(map[string]bool)
+		}
+		members[slave.Group][slave.Name] = true
+	}
+	return groups, members
+}
 
-	if err != nil {
-		return BuildReport{
-			b.slave,
-			"failed to retrieve outputs",
-			err,
-		}
-	}
-
-	ssh = exec.Command(
-		"ssh",
-		b.slave.Addr,
-		fmt.Sprintf(
-			"/bin/rm -rf %s",
-			b.slave.Path,
-		),
-	)
-
-	fmt.Fprintf(b.w, "## build -- cleaning up...\n")
-	b.w.Sync()
-	ssh.Stdout = b.w
-	ssh.Stderr = b.w
-	err = ssh.Run()
-	if err != nil {
-		return BuildReport{
-			b.slave,
-			"clean-up failed",
-			err,
+func groupName(group string) string {
+	if group == "" {
+		return "(no group)"
+	}
+	return group
+}
+
+// summarizeGroups prints the number of builds of each status, per group.
+func summarizeGroups(groups []string, members map[string]map[string]bool, reports []bldbot.BuildReport) {
+	summaryf(">>> summary by group:\n")
+	for _, group := range groups {
+		counts := make(map[string]int)
+		var failed []string
+		for _, report := range reports {
+			if !members[group][report.Slave.Name] {
+				continue
+			}
+			status := report.Status()
+			counts[status]++
+			if report.Failed() {
+				failed = append(failed, report.Slave.Label())
+			}
+		}
+		line := fmt.Sprintf(" [%s] %d ok", groupName(group), counts[bldbot.StatusOK])
+		for _, status := range []string{bldbot.StatusSkipped, bldbot.StatusDetached, bldbot.StatusFailed, bldbot.StatusUnreachable, bldbot.StatusCancelled, bldbot.StatusAborted} {
+			if counts[status] > 0 {
+				line += fmt.Sprintf(", %d %s", counts[status], status)
+			}
+		}
+		if len(failed) > 0 {
+			line += fmt.Sprintf(" (%s)", strings.Join(failed, ", "))
 		}
+		summaryf("%s\n", line)
 	}
+}
 
-	return BuildReport{b.slave, "ok", nil}
+// summarize prints the outcome of a build and returns whether it succeeded.
+func summarize(report bldbot.BuildReport) bool {
+	emit(bldbot.Event{
+		Type:   bldbot.EventSlaveCompleted,
+		Slave:  report.Slave.Name,
+		Status: report.Status(),
+		Msg:    report.Msg,
+	})
+	if report.Rescheduled > 0 {
+		summaryf(" %s: rescheduled %d time(s) (slave unreachable)\n", report.Slave.Label(), report.Rescheduled)
+	}
+	if slowPing(report.Ping) {
+		summaryf(" %s: slow to respond (ping %v)\n", report.Slave.Label(), report.Ping.Round(time.Millisecond))
+	}
+	defer func() {
+		for _, line := range report.Tail {
+			summaryf(" %s | %s\n", report.Slave.Label(), line)
+		}
+	}()
+
+	switch {
+	case report.Unreachable:
+		log.Printf("%s\n", report.Err.Error())
+		return false
+	case report.Aborted:
+		log.Printf("build not run for slave [%s]: %s\n", report.Slave.Name, report.Msg)
+		return false
+	case report.Cancelled:
+		log.Printf(
+			"build cancelled for slave [%s] (attempts=%d)\n",
+			report.Slave.Name, report.Attempts,
+		)
+		return false
+	case report.Err != nil:
+		log.Printf(
+			"build failed for slave [%s] (attempts=%d):\n%v\nmsg=%s\n",
+			report.Slave.Name, report.Attempts, report.Err, report.Msg,
+		)
+		return false
+	}
+	if report.Skipped {
+		summaryf(" %s: %s\n", report.Slave.Label(), report.Msg)
+		return true
+	}
+	if report.Msg != "ok" || report.Attempts > 1 {
+		summaryf(" %s: %s (attempts=%d)\n", report.Slave.Label(), report.Msg, report.Attempts)
+	}
+	for _, url := range report.Uploads {
+		summaryf(" %s: uploaded [%s]\n", report.Slave.Label(), url)
+	}
+	for _, image := range report.Images {
+		summaryf(" %s: pushed [%s]\n", report.Slave.Label(), image)
+	}
+	if report.SBOM != "" {
+		summaryf(" %s: SBOM [%s]\n", report.Slave.Label(), report.SBOM)
+	}
+	for _, output := range report.FailedOutputs {
+		summaryf(" %s: could not retrieve [%s]\n", report.Slave.Label(), output)
+	}
+	for _, dep := range report.Slave.DependsOn {
+		if files, ok := report.Inputs[dep]; ok {
+			summaryf(" %s: inputs from %s: %s\n", report.Slave.Label(), dep, baseNames(files))
+		}
+	}
+	for _, target := range report.Targets {
+		if target.Status != bldbot.StatusOK {
+			summaryf(" %s: target [%s] failed (exit code %d)\n", report.Slave.Label(), target.Name, target.ExitCode)
+		}
+	}
+	if report.Fallback {
+		summaryf(" %s: built with the fallback build-script [%s]\n", report.Slave.Label(), report.Slave.LocalCommandFileName())
+	}
+	return true
 }
 
 func main() {
-	fmt.Printf(">>>\n>>> buildbot <<<\n>>>\n")
 	flag.Parse()
-
-	config := Config{
-		Slaves: make([]Slave, 0, 2),
+	if *g_defaults != "" {
+		err := loadFlagDefaults(*g_defaults)
+		if err != nil {
+			log.Fatalf("buildbot: could not load defaults file [%s] (%v)\n", *g_defaults, err)
+		}
 	}
-	f, err := os.Open(*g_config)
-	if err != nil {
-		log.Panicf("buildbot: could not open file [%s] (%v)\n", *g_config, err)
+	if (*g_transition_hook != "" || *g_transition_webhook != "") && *g_state_file == "" {
+		log.Fatalf("buildbot: -transition-hook and -transition-webhook require -state-file\n")
+	}
+	switch *g_transport {
+	case "scp", "tar", "auto", "rsync":
+	default:
+		log.Fatalf("buildbot: invalid -transport %q (want scp, tar, auto or rsync)\n", *g_transport)
+	}
+	if *g_command_hook != "" {
+		commandHook = execCommandHook(*g_command_hook)
+	}
+	if *g_bind_address != "" && net.ParseIP(*g_bind_address) == nil {
+		log.Fatalf("buildbot: invalid -bind-address %q (want an IP address)\n", *g_bind_address)
+	}
+	if *g_connect_rate > 0 {
+		connections = bldbot.NewTokenBucket(*g_connect_rate)
+	}
+	if *g_total_bwlimit > 0 {
+		bandwidth = bldbot.NewTokenBucket(*g_total_bwlimit * 1024)
+	}
+	if *g_max_inflight_bytes > 0 {
+		inflight = bldbot.NewByteBudget(*g_max_inflight_bytes)
+	}
+	if *g_dest_template != "" {
+		var err error
+		destTemplate, err = bldbot.ParseDestTemplate(*g_dest_template)
+		if err != nil {
+			log.Fatalf("buildbot: invalid -dest-template (err=%v)\n", err)
+		}
+	}
+	if !validRunLabel(*g_run_label) {
+		log.Fatalf("buildbot: invalid -run-label %q (want letters, digits, '.', '_' or '-')\n", *g_run_label)
+	}
+	if *g_tui && *g_events_json {
+		log.Fatalf("buildbot: -tui and -events-json are mutually exclusive\n")
+	}
+	if *g_events_json {
+		// keep stdout for the events.
+		g_quiet = 2
+		addEventSink(jsonEventSink())
 	}
-	defer f.Close()
-	in, err := ioutil.ReadAll(f)
-	err = yml.Unmarshal(in, &config)
+	if *g_trace != "" {
+		addEventSink(traceEventSink(*g_trace))
+	}
+	if *g_banner != "" {
+		progressf(">>>\n>>> %s <<<\n>>>\n", *g_banner)
+	}
+
+	slaves, err := bldbot.LoadSlaves(*g_config)
 	if err != nil {
-		log.Panicf("buildbot: could not decode file [%s] (%v)\n", *g_config, err)
+		log.Panicf("buildbot: could not load slaves (%v)\n", err)
+	}
+	if hasDependencies(slaves) && *g_wave_size > 0 {
+		log.Fatalf("buildbot: -wave-size is not supported with DependsOn\n")
+	}
+	if hasDependencies(slaves) && *g_detached {
+		log.Fatalf("buildbot: -detached is not supported with DependsOn\n")
+	}
+	if *g_detached {
+		if errs := detachedConflicts(slaves); len(errs) > 0 {
+			log.Printf("buildbot: unsupported settings with -detached:\n")
+			for _, err := range errs {
+				log.Printf(" - %v\n", err)
+			}
+			os.Exit(2)
+		}
+	}
+	if errs := bldbot.CheckSlaves(slaves); len(errs) > 0 {
+		log.Printf("buildbot: invalid configuration of the slaves:\n")
+		for _, err := range errs {
+			log.Printf(" - %v\n", err)
+		}
+		os.Exit(2)
+	}
+	if *g_retrieve_only != "" {
+		if !*g_persistent_workdir {
+			log.Fatalf("buildbot: -retrieve-only requires -persistent-workdir\n")
+		}
+		slaves, err = selectSlaves(slaves, *g_retrieve_only)
+		if err != nil {
+			log.Printf("buildbot: %v\n", err)
+			os.Exit(2)
+		}
 	}
 
-	if len(config.Slaves) <= 0 {
+	if len(slaves) <= 0 {
 		log.Printf("buildbot: found no slave to send work to.\n")
 		os.Exit(2)
 	}
 
-	slaves := config.Slaves
 	//fmt.Printf(">>> %v\n", slaves)
-	builders := make([]*Builder, 0, len(slaves))
+	if *g_allowed_hosts != "" {
+		err = checkAllowedHosts(slaves, *g_allowed_hosts)
+		if err != nil {
+			log.Printf("buildbot: %v\n", err)
+			os.Exit(2)
+		}
+	}
 
-	for _, slave := range slaves {
-		err = slave.Ping()
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "gc":
+			os.Exit(runGC(slaves, flag.Args()[1:]))
+		case "collect":
+			os.Exit(runCollect(slaves, flag.Args()[1:]))
+		default:
+			log.Printf("buildbot: unknown command %q\n", flag.Arg(0))
+			os.Exit(2)
+		}
+	}
+
+	var stages []stageRun
+	if *g_pipeline != "" {
+		switch {
+		case *g_watch:
+			log.Fatalf("buildbot: -pipeline and -watch are mutually exclusive\n")
+		case *g_detached:
+			log.Fatalf("buildbot: -pipeline and -detached are mutually exclusive\n")
+		case *g_retrieve_only != "":
+			log.Fatalf("buildbot: -pipeline and -retrieve-only are mutually exclusive\n")
+		case *g_baseline != "":
+			log.Fatalf("buildbot: -pipeline and -baseline are mutually exclusive\n")
+		case !*g_persistent_workdir:
+			log.Fatalf("buildbot: -pipeline requires -persistent-workdir (the stages build in the same work directory)\n")
+		}
+		stages, err = loadPipeline(*g_pipeline, slaves)
 		if err != nil {
-			log.Printf("%s\n", err.Error())
-			continue
+			log.Printf("buildbot: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	if *g_explain {
+		if stages == nil {
+			explain(os.Stdout, slaves)
+			return
 		}
-		//fmt.Printf("--- slave [%s] ---\n%v\n", slave.Name, string(out))
-		err = os.MkdirAll("logs", 0755)
+		for i, stage := range stages {
+			fmt.Printf(">>> stage [%s] (%d/%d)\n", stage.Name, i+1, len(stages))
+			explain(os.Stdout, stage.slaves)
+		}
+		return
+	}
+
+	if *g_estimate {
+		err = estimate(os.Stdout, slaves)
 		if err != nil {
-			log.Panicf("could create logs directory ! (err=%v)\n", err)
+			log.Printf("buildbot: %v\n", err)
+			os.Exit(2)
+		}
+		return
+	}
+
+	if *g_preflight {
+		var errs []error
+		if stages == nil {
+			errs = preflight(slaves)
 		}
+		for _, stage := range stages {
+			for _, err := range preflight(stage.slaves) {
+				errs = append(errs, fmt.Errorf("stage [%s]: %v", stage.Name, err))
+			}
+		}
+		if len(errs) > 0 {
+			log.Printf("buildbot: preflight checks failed:\n")
+			for _, err := range errs {
+				log.Printf(" - %v\n", err)
+			}
+			os.Exit(2)
+		}
+	}
+
+	if *g_validate_remote {
+		os.Exit(validateRemote(slaves))
+	}
 
-		err = os.MkdirAll("output", 0755)
+	if *g_shellcheck != "" {
+		err = shellcheck(slaves, *g_shellcheck)
 		if err != nil {
-			log.Panicf("could create output directory ! (err=%v)\n", err)
+			log.Printf("buildbot: %v\n", err)
+			os.Exit(1)
 		}
+	}
 
-		fname := filepath.Join("logs", fmt.Sprintf("%s.txt", slave.Name))
-		logfile, err := os.Create(fname)
+	if *g_baseline != "" {
+		baselines, err = bldbot.LoadBaseline(*g_baseline, *g_update_baseline)
 		if err != nil {
-			log.Printf(
-				"could not create logfile [%s] for slave [%s] (err=%v)\n",
-				fname, slave.Name, err,
-			)
+			log.Fatalf("buildbot: could not load baseline [%s] (%v)\n", *g_baseline, err)
 		}
-		tmpdir, err := ioutil.TempDir("", "go-bldbot-"+time.Now().Format("20060102")+"-")
+	}
+
+	var ctl *controller
+	if *g_control_addr != "" {
+		ctl = newController()
+		ctl.serve(*g_control_addr)
+	}
+
+	var lock *fleetLock
+	if *g_lock_file != "" {
+		lock, err = acquireLock(*g_lock_file, *g_lock_wait, *g_lock_max_age)
 		if err != nil {
-			log.Panicf("could not create tempdir for slave [%s] (err=%v)\n",
-				slave.Name, err,
-			)
+			log.Printf("buildbot: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	if *g_tui {
+		dashboard = startTUI(slaves, lock.release)
+		if dashboard != nil && g_quiet < 1 {
+			// progress messages are superseded by the dashboard.
+			g_quiet = 1
+		}
+	}
+	var allgood bool
+	if stages != nil {
+		allgood = runPipeline(stages, ctl)
+	} else {
+		allgood = runSlaves(slaves, ctl)
+	}
+	dashboard.stop()
+	lock.release()
+	if *g_watch {
+		watch(slaves, ctl)
+	}
+	ctl.drain(2 * logPoll)
+	if !allgood {
+		os.Exit(1)
+	}
+}
+
+// runSlaves runs the builds of the given slaves and returns whether they
+// all succeeded.
+func runSlaves(slaves []bldbot.Slave, ctl *controller) bool {
+	var err error
+	run := newRunID()
+	emit(bldbot.Event{Type: bldbot.EventRunStarted, Run: run, Label: *g_run_label, Slaves: len(slaves)})
+	builders := make([]*bldbot.Builder, 0, len(slaves))
+	reports := make([]bldbot.BuildReport, 0, len(slaves))
+
+	if *g_archive != "" {
+		archive, err = bldbot.CreateArchive(bldbot.StageName(*g_archive, pipelineStage))
+		if err != nil {
+			log.Printf("buildbot: could not create archive [%s] (err=%v)\n", bldbot.StageName(*g_archive, pipelineStage), err)
+			return false
 		}
-		slave.Path = tmpdir
-		os.RemoveAll(tmpdir)
+	}
 
-		builders = append(builders, &Builder{
-			slave: slave,
-			w:     logfile,
-		})
+	var pings map[string]bldbot.PingResult
+	if *g_wait_for_slaves > 0 {
+		pings = waitForSlaves(slaves, *g_wait_for_slaves)
 	}
 
-	fmt.Printf(">>> found the following builders:\n")
-	for _, builder := range builders {
-		fmt.Printf(
-			" %s \t(%s:%s)\n",
-			builder.slave.Name,
-			builder.slave.Addr,
-			builder.slave.Path,
+	for _, slave := range slaves {
+		ping, pinged := pings[slave.Name]
+		if !pinged {
+			ping = slave.TimedPing(flagOptions())
+		}
+		err = ping.Err
+		if err != nil {
+			reports = append(reports, bldbot.BuildReport{
+				Slave:       slave,
+				Msg:         "unreachable",
+				Err:         err,
+				Unreachable: true,
+			})
+			continue
+		}
+		builder, report := bldbot.NewBuilder(slave, ping.Latency, flagOptions())
+		if builder == nil {
+			reports = append(reports, report)
+			continue
+		}
+		builders = append(builders, builder)
+	}
+
+	quorate := len(builders) >= *g_quorum
+	if !quorate {
+		log.Printf(
+			"buildbot: only %d/%d slaves reachable, below the quorum (%d), not running the builds\n",
+			len(builders), len(slaves), *g_quorum,
 		)
+		for _, builder := range builders {
+			builder.Close()
+			reports = append(reports, bldbot.BuildReport{
+				Slave:   builder.Slave(),
+				Msg:     "not run (below quorum)",
+				Err:     fmt.Errorf("quorum of reachable slaves not met"),
+				Aborted: true,
+			})
+		}
+		builders = nil
 	}
 
-	fmt.Printf(">>> launching builders... (parallel=%v)\n", *g_parallel)
-	done := make(chan BuildReport)
-	allgood := true
-	for _, builder := range builders {
-		fmt.Printf(" %s...\n", builder.slave.Name)
-		if *g_parallel {
-			go func(builder *Builder) {
-				done <- builder.run()
-			}(builder)
-		} else {
-			resp := builder.run()
-			if resp.err != nil {
-				log.Printf(
-					"build failed for slave [%s]:\n%v\nmsg=%s\n",
-					resp.slave.Name, resp.err, resp.msg,
-				)
-				allgood = false
+	progressf(">>> found the following builders:\n")
+	groups, members := groupSlaves(slaves)
+	for _, group := range groups {
+		indent := ""
+		if len(groups) > 1 || group != "" {
+			progressf(" [%s]\n", groupName(group))
+			indent = " "
+		}
+		for _, builder := range builders {
+			slave := builder.Slave()
+			if !members[group][slave.Name] {
 				continue
 			}
+			progressf(
+				"%s %s \t(%s:%s)\n",
+				indent,
+				slave.Label(),
+				slave.Addr,
+				slave.Path,
+			)
 		}
 	}
-	fmt.Printf(">>> launching builders... (parallel=%v) [done]\n", *g_parallel)
 
-	if *g_parallel {
-		for _ = range builders {
-			report := <-done
-			if report.err != nil {
-				log.Printf(
-					"build failed for slave [%s]:\n%v\n",
-					report.slave.Name, report.err,
-				)
-				allgood = false
-				continue
+	if *g_shuffle {
+		seed := *g_seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		progressf(">>> shuffling builders (seed=%d)\n", seed)
+		shuffle(seed, len(builders), func(i, j int) {
+			builders[i], builders[j] = builders[j], builders[i]
+		})
+	}
+
+	bldbot.SetupHosts(builders)
+	ctl.register(builders)
+
+	allgood := quorate
+	for _, report := range reports {
+		if !summarize(report) && (*g_require_all || !report.Unreachable) {
+			allgood = false
+		}
+	}
+
+	limits := newLimiter(*g_max_parallel, *g_per_host_parallel)
+	waves := splitWaves(builders, *g_wave_size)
+	// the builds only retrieving their outputs do not need their inputs.
+	pipeline := hasDependencies(slaves) && *g_retrieve_only == ""
+	var st states
+	if pipeline {
+		// each wave a stage of the dependencies.
+		waves = dependencyStages(builders)
+		if *g_state_file != "" {
+			st, err = loadStates(bldbot.StageName(*g_state_file, pipelineStage))
+			if err != nil {
+				log.Printf("could not load state file [%s] (err=%v)\n", bldbot.StageName(*g_state_file, pipelineStage), err)
+			}
+		}
+	}
+	for i, wave := range waves {
+		size := len(wave)
+		failed := 0
+		if pipeline {
+			var blocked []bldbot.BuildReport
+			wave, blocked = linkInputs(wave, reports, st)
+			for _, report := range blocked {
+				reports = append(reports, report)
+				summarize(report)
+				failed++
+			}
+		}
+		results := launch(wave, limits)
+		for _, report := range results {
+			if *g_wave_size > 0 {
+				report.Wave = i + 1
+			}
+			reports = append(reports, report)
+			if report.Failed() {
+				failed++
+			}
+		}
+		if failed > 0 {
+			allgood = false
+		}
+
+		if i+1 < len(waves) && float64(failed)*100 > *g_wave_fail_threshold*float64(size) {
+			log.Printf(
+				"buildbot: %d/%d failures in wave %d exceed the threshold (%v%%), aborting the run\n",
+				failed, size, i+1, *g_wave_fail_threshold,
+			)
+			for _, rest := range waves[i+1:] {
+				for _, builder := range rest {
+					builder.Close()
+					report := bldbot.BuildReport{
+						Slave:   builder.Slave(),
+						Msg:     fmt.Sprintf("aborted after wave %d", i+1),
+						Err:     fmt.Errorf("run aborted"),
+						Aborted: true,
+					}
+					reports = append(reports, report)
+					summarize(report)
+				}
 			}
+			break
+		}
+	}
+
+	for _, builder := range builders {
+		builder.Close()
+	}
+
+	if archive != nil {
+		err = archive.Close()
+		archive = nil
+		if err != nil {
+			log.Printf("could not write archive [%s] (err=%v)\n", bldbot.StageName(*g_archive, pipelineStage), err)
+			allgood = false
 		}
 	}
 
-	fmt.Printf(">>> all good: %v\n", allgood)
+	if *g_diff_last {
+		diffLast(reports)
+	}
+
+	if *g_consolidated_log != "" {
+		fname := labelled(*g_consolidated_log)
+		err = writeConsolidatedLog(fname, slaves, builders, reports)
+		if err != nil {
+			log.Printf("could not write consolidated log [%s] (err=%v)\n", fname, err)
+			allgood = false
+		}
+	}
+
+	if *g_update_baseline && baselines != nil {
+		err = baselines.Update(reports)
+		if err == nil {
+			err = baselines.Save(*g_baseline)
+		}
+		if err != nil {
+			log.Printf("could not update baseline [%s] (err=%v)\n", *g_baseline, err)
+			allgood = false
+		}
+	}
+
+	recordOutputs(reports)
+
+	if *g_state_file != "" {
+		fname := bldbot.StageName(*g_state_file, pipelineStage)
+		err = updateStates(fname, reports)
+		if err != nil {
+			log.Printf("could not update state file [%s] (err=%v)\n", fname, err)
+			allgood = false
+		}
+	}
+
+	if *g_detached {
+		err = recordDetached(*g_detached_state, run, reports)
+		if err != nil {
+			log.Printf("could not record the detached builds into [%s] (err=%v)\n", *g_detached_state, err)
+			allgood = false
+		}
+	}
+
+	if *g_ledger != "" {
+		err = appendLedger(*g_ledger, reports)
+		if err != nil {
+			log.Printf("could not append to ledger [%s] (err=%v)\n", *g_ledger, err)
+			allgood = false
+		}
+	}
+
+	if *g_manifest_out != "" {
+		fname := labelled(*g_manifest_out)
+		err = writeManifest(fname, reports)
+		if err != nil {
+			log.Printf("could not write manifest [%s] (err=%v)\n", fname, err)
+			allgood = false
+		}
+	}
+
+	if *g_report_json != "" {
+		fname := labelled(*g_report_json)
+		err = writeReportJSON(fname, reports)
+		if err != nil {
+			log.Printf("could not write report [%s] (err=%v)\n", fname, err)
+			allgood = false
+		}
+	}
+
+	if len(groups) > 1 || groups[0] != "" {
+		summarizeGroups(groups, members, reports)
+	}
+
+	if *g_stats {
+		printStats(computeStats(reports))
+	}
+
+	if *g_dedup_store != "" {
+		saved, dups := int64(0), 0
+		for _, report := range reports {
+			if report.Saved > 0 {
+				saved += report.Saved
+				dups++
+			}
+		}
+		summaryf(">>> dedup: %s saved (%d build(s) with already stored outputs)\n", bldbot.HumanSize(saved), dups)
+	}
+
+	status := "ok"
 	if !allgood {
-		os.Exit(1)
+		status = "failed"
+	}
+	emit(bldbot.Event{Type: bldbot.EventRunCompleted, Status: status, Run: run, Label: *g_run_label})
+	if (g_quiet < 2 || !allgood) && !*g_events_json {
+		fmt.Printf(">>> all good: %v\n", allgood)
 	}
+	return allgood
 }
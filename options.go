@@ -0,0 +1,83 @@
+package main
+
+import (
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+// The resources shared by the builds of the run, set up by main from the
+// flags.
+var (
+	commandHook  bldbot.CommandHook   // -command-hook
+	destTemplate *bldbot.DestTemplate // -dest-template
+	archive      *bldbot.Archive      // -archive, while a run streams into it
+	bandwidth    *bldbot.TokenBucket  // -total-bwlimit
+	inflight     *bldbot.ByteBudget   // -max-inflight-bytes
+	connections  *bldbot.TokenBucket  // -connect-rate
+)
+
+// flagOptions returns the Options of the builds as set by the flags.
+func flagOptions() bldbot.Options {
+	opts := bldbot.Options{
+		DefaultTimeout:       *g_timeout,
+		MaxTimeout:           *g_max_timeout,
+		MaxBuildDuration:     *g_max_build_duration,
+		Retries:              *g_build_retries,
+		TransferRetries:      *g_transfer_retries,
+		TransportMode:        *g_transport,
+		SSHOptions:           sshFlagOptions(),
+		Connections:          connections,
+		CommandHook:          commandHook,
+		PersistentWorkdir:    *g_persistent_workdir,
+		RetrieveOnly:         *g_retrieve_only != "",
+		Detached:             *g_detached,
+		CompressUpload:       *g_compress_upload,
+		Stagger:              *g_stagger,
+		Nice:                 *g_nice,
+		IONice:               *g_ionice,
+		NoTime:               *g_no_time,
+		LoginShell:           *g_login_shell,
+		MaxLogSize:           *g_max_log_size,
+		SplitLogs:            *g_split_logs,
+		TimestampLogs:        *g_timestamp_logs,
+		Syslog:               *g_syslog,
+		DiffLast:             *g_diff_last,
+		LogBufferBytes:       *g_log_buffer_bytes,
+		Progressf:            progressf,
+		Summaryf:             summaryf,
+		Events:               emit,
+		Stage:                pipelineStage,
+		RunLabel:             *g_run_label,
+		SysInfo:              *g_sysinfo,
+		SysInfoCommands:      g_sysinfo_cmds,
+		PingLatencyWarn:      *g_ping_latency_warn,
+		PingLatencyThreshold: *g_ping_latency_threshold,
+		ClockSkew:            *g_clock_skew,
+		ClockSkewFail:        *g_clock_skew_fail,
+		Collector:            *g_collector,
+		Archive:              archive,
+		Dest:                 destTemplate,
+		Progress:             *g_progress,
+		Bandwidth:            bandwidth,
+		Inflight:             inflight,
+		AllowPartialOutputs:  *g_allow_partial_outputs,
+		RequireOutputs:       *g_require_outputs,
+		FailPatterns:         g_fail_patterns,
+		FailOnStderr:         *g_fail_on_stderr,
+		VerifyTypes:          *g_verify_types,
+		Baseline:             baselines,
+		UpdateBaseline:       *g_update_baseline,
+		TailLines:            *g_tail_lines,
+		SignKey:              *g_sign_key,
+		UploadS3:             *g_upload_s3,
+		UploadRequired:       *g_upload_required,
+		DedupStore:           *g_dedup_store,
+		SBOMTool:             *g_sbom_tool,
+		Reschedule:           *g_reschedule,
+		RescheduleDelay:      *g_reschedule_delay,
+		MaxReschedules:       *g_max_reschedules,
+	}
+	if dashboard != nil {
+		opts.BuildOutput = dashboard.output
+	}
+	return opts
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"regexp"
+	"strings"
+)
+
+var g_log_buffer_bytes = flag.Int("log-buffer-bytes", 0, "cap (in bytes) on each buffer of build output held in memory for a slave (-tail-lines, -fail-on-pattern and incomplete lines, the tail of -max-log-size); smaller means a shorter tail and less context (0: no cap)")
+
+// regexpsFlag is a repeatable flag holding a list of regular expressions.
+type regexpsFlag []*regexp.Regexp
+
+func (f *regexpsFlag) String() string {
+	res := make([]string, 0, len(*f))
+	for _, re := range *f {
+		res = append(res, re.String())
+	}
+	return strings.Join(res, ",")
+}
+
+func (f *regexpsFlag) Set(s string) error {
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, re)
+	return nil
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+var g_ping_latency_warn = flag.Duration("ping-latency-warn", 5*time.Second, "ping latency above which a slave is reported as slow to respond (0: never)")
+var g_ping_latency_threshold = flag.Duration("ping-latency-threshold", 0, "ping latency above which the build of a slave fails, without being run (0: never)")
+
+// slowPing returns whether the ping latency is above -ping-latency-warn.
+func slowPing(latency time.Duration) bool {
+	return *g_ping_latency_warn > 0 && latency > *g_ping_latency_warn
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+	yml "github.com/gonuts/yaml"
+)
+
+var g_pipeline = flag.String("pipeline", "", "(YAML, or JSON for .json files) manifest of the stages of the run, each run over its slaves once the previous one succeeded on all of its own")
+
+// Pipeline is the manifest of a multi-stage run (-pipeline.)
+type Pipeline struct {
+	Stages []Stage
+}
+
+// Stage is a stage of a pipeline: a run of its own, with the reports
+// labelled with its Name (appended to any -run-label.)
+type Stage struct {
+	Name string
+
+	// Slaves and Groups select the slaves of the stage: those named in the
+	// comma-separated list Slaves (* for all) or in one of the Groups.
+	// (default: all the slaves)
+	Slaves string
+	Groups []string
+
+	// Script is the build-script (relative to the directory of each slave)
+	// run instead of build.sh, e.g. package.sh; Command, if set, a command
+	// run from the work directory instead of any build-script.
+	Script  string
+	Command string
+}
+
+// stageRun is a stage with the slaves it runs over, set up for it.
+type stageRun struct {
+	Stage
+	slaves []bldbot.Slave
+}
+
+// loadPipeline decodes the pipeline of fname and returns its stages over
+// the slaves.
+func loadPipeline(fname string, slaves []bldbot.Slave) ([]stageRun, error) {
+	var p Pipeline
+	in, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, fmt.Errorf("could not open file [%s] (%v)", fname, err)
+	}
+	if strings.ToLower(filepath.Ext(fname)) == ".json" {
+		err = json.Unmarshal(in, &p)
+	} else {
+		err = yml.Unmarshal(in, &p)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not decode file [%s] (%v)", fname, err)
+	}
+	if len(p.Stages) <= 0 {
+		return nil, fmt.Errorf("pipeline [%s] has no stage", fname)
+	}
+
+	runs := make([]stageRun, 0, len(p.Stages))
+	seen := make(map[string]bool, len(p.Stages))
+	for i, stage := range p.Stages {
+		switch {
+		case stage.Name == "":
+			return nil, fmt.Errorf("stage #%d has no Name", i+1)
+		case seen[stage.Name]:
+			return nil, fmt.Errorf("duplicate stage [%s]", stage.Name)
+		case !validRunLabel(stage.Name):
+			return nil, fmt.Errorf("invalid name %q of stage #%d (want letters, digits, '.', '_' or '-')", stage.Name, i+1)
+		case stage.Script != "" && stage.Command != "":
+			return nil, fmt.Errorf("stage [%s]: Script and Command are mutually exclusive", stage.Name)
+		}
+		seen[stage.Name] = true
+
+		selected, err := stage.selectSlaves(slaves)
+		if err != nil {
+			return nil, fmt.Errorf("stage [%s]: %v", stage.Name, err)
+		}
+		if len(selected) <= 0 {
+			return nil, fmt.Errorf("stage [%s] selects no slave", stage.Name)
+		}
+		for j := range selected {
+			selected[j] = selected[j].WithScript(stage.Script, stage.Command)
+		}
+		runs = append(runs, stageRun{Stage: stage, slaves: selected})
+	}
+	return runs, nil
+}
+
+// selectSlaves returns (copies of) the slaves of the stage, in their order.
+func (st Stage) selectSlaves(slaves []bldbot.Slave) ([]bldbot.Slave, error) {
+	if st.Slaves == "" && len(st.Groups) <= 0 {
+		return append([]bldbot.Slave(nil), slaves...), nil
+	}
+	want := make(map[string]bool)
+	if st.Slaves != "" {
+		named, err := selectSlaves(slaves, st.Slaves)
+		if err != nil {
+			return nil, err
+		}
+		for _, slave := range named {
+			want[slave.Name] = true
+		}
+	}
+	groups := make(map[string]bool, len(st.Groups))
+	for _, group := range st.Groups {
+		groups[group] = true
+	}
+	selected := make([]bldbot.Slave, 0, len(slaves))
+	for _, slave := range slaves {
+		if want[slave.Name] || groups[slave.Group] {
+			selected = append(selected, slave)
+		}
+	}
+	return selected, nil
+}
+
+// pipelineStage is the name of the stage being run by runPipeline ("" out
+// of a -pipeline.)
+var pipelineStage string
+
+// label returns the -run-label of the stage.
+func (st Stage) label(base string) string {
+	if base == "" {
+		return st.Name
+	}
+	return base + "-" + st.Name
+}
+
+// runPipeline runs the stages in order, each once the previous one
+// succeeded on all of its slaves, and returns whether they all succeeded.
+func runPipeline(runs []stageRun, ctl *controller) bool {
+	base := *g_run_label
+	defer func() {
+		*g_run_label = base
+		pipelineStage = ""
+	}()
+	for i, run := range runs {
+		names := make([]string, 0, len(run.slaves))
+		for _, slave := range run.slaves {
+			names = append(names, slave.Name)
+		}
+		summaryf(">>> stage [%s] (%d/%d) on %v\n", run.Name, i+1, len(runs), names)
+		*g_run_label = run.label(base)
+		pipelineStage = run.Name
+		if runSlaves(run.slaves, ctl) {
+			summaryf(">>> stage [%s]: ok\n", run.Name)
+			continue
+		}
+		summaryf(">>> stage [%s]: FAILED\n", run.Name)
+		for _, rest := range runs[i+1:] {
+			summaryf(">>> stage [%s]: not run\n", rest.Name)
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+// preflight checks the local setup of the run before any remote work: the
+// programs needed by the run are installed, the build-scripts exist and the
+// local logs and output directories are writable.
+// It returns the list of all the problems found.
+func preflight(slaves []bldbot.Slave) []error {
+	var errs []error
+	need := func(prog, why string) {
+		if _, err := exec.LookPath(prog); err != nil {
+			errs = append(errs, fmt.Errorf("%s not found (needed %s)", prog, why))
+		}
+	}
+
+	remote := false
+	pinned := false
+	for i := range slaves {
+		slave := &slaves[i]
+		remote = remote || !slave.IsLocal()
+		if slave.Command() != "" {
+			continue
+		}
+		if slave.ScriptRef != "" {
+			pinned = true
+			continue
+		}
+		fname := slave.LocalCommandFileName()
+		if fi, err := os.Stat(fname); err != nil {
+			errs = append(errs, fmt.Errorf("slave [%s]: no build-script (%v)", slave.Name, err))
+		} else if fi.IsDir() {
+			errs = append(errs, fmt.Errorf("slave [%s]: build-script [%s] is a directory", slave.Name, fname))
+		}
+		if slave.FallbackScript != "" {
+			fname = filepath.Join(slave.Name, slave.FallbackScript)
+			if fi, err := os.Stat(fname); err != nil {
+				errs = append(errs, fmt.Errorf("slave [%s]: no FallbackScript (%v)", slave.Name, err))
+			} else if fi.IsDir() {
+				errs = append(errs, fmt.Errorf("slave [%s]: FallbackScript [%s] is a directory", slave.Name, fname))
+			}
+		}
+	}
+
+	if remote {
+		need("ssh", "to reach the slaves")
+		switch *g_transport {
+		case "scp", "auto":
+			need("scp", "by -transport "+*g_transport)
+		case "rsync":
+			need("rsync", "by -transport rsync")
+		}
+	}
+	if _, err := exec.LookPath("git"); pinned && err != nil {
+		errs = append(errs, fmt.Errorf("git not found (needed by ScriptRef)"))
+	} else if pinned {
+		for i := range slaves {
+			slave := &slaves[i]
+			if slave.ScriptRef == "" {
+				continue
+			}
+			_, _, remove, err := slave.ScriptFile()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("slave [%s]: %v", slave.Name, err))
+				continue
+			}
+			remove()
+		}
+	}
+	if *g_sign_key != "" {
+		need("gpg", "by -sign-key")
+	}
+	if *g_upload_s3 != "" {
+		need("aws", "by -upload-s3")
+	}
+	if *g_diff_last {
+		need("diff", "by -diff-last")
+	}
+
+	for _, dir := range []string{"logs", "output"} {
+		err := os.MkdirAll(dir, 0755)
+		if err == nil {
+			var f *os.File
+			f, err = ioutil.TempFile(dir, ".preflight-")
+			if err == nil {
+				f.Close()
+				os.Remove(f.Name())
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("directory [%s] is not writable (%v)", dir, err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+// reportRecord is the JSON representation of a build report (-report-json.)
+type reportRecord struct {
+	Label    string   `json:"label,omitempty"`
+	Slave    string   `json:"slave"`
+	Addr     string   `json:"addr"`
+	Group    string   `json:"group,omitempty"`
+	Status   string   `json:"status"`
+	Msg      string   `json:"msg"`
+	Error    string   `json:"error,omitempty"`
+	Attempts int      `json:"attempts"`
+	ExitCode int      `json:"exit_code,omitempty"`
+	Duration float64  `json:"duration,omitempty"` // run time of the build-script, in seconds
+	Outputs  []string `json:"outputs,omitempty"`
+	Uploads  []string `json:"uploads,omitempty"`
+
+	Signatures []string `json:"signatures,omitempty"`
+	Tail       []string `json:"tail,omitempty"`
+	Wave       int      `json:"wave,omitempty"`
+	ScriptRev  string   `json:"script_rev,omitempty"`
+
+	Artifacts []bldbot.ArtifactInfo `json:"artifacts,omitempty"`
+
+	Rescheduled int `json:"rescheduled,omitempty"`
+
+	Images []string `json:"images,omitempty"`
+
+	Ping float64 `json:"ping,omitempty"` // ping latency of the slave, in seconds
+
+	ClockSkew float64 `json:"clock_skew,omitempty"` // of the slave (ahead of the local clock), in seconds
+
+	FailedOutputs []string `json:"failed_outputs,omitempty"`
+
+	SBOM string `json:"sbom,omitempty"` // local path of the SBOM of the build
+
+	Targets []bldbot.TargetResult `json:"targets,omitempty"`
+
+	Inputs map[string][]string `json:"inputs,omitempty"` // outputs of the dependencies uploaded, by slave
+
+	Script   string `json:"script"`             // build-script of the (last) build run
+	Fallback bool   `json:"fallback,omitempty"` // whether it was the FallbackScript
+
+	SysInfo map[string]string `json:"sysinfo,omitempty"`
+}
+
+// record returns the JSON representation of the report r.
+func record(r bldbot.BuildReport) reportRecord {
+	rec := reportRecord{
+		Label:    *g_run_label,
+		Slave:    r.Slave.Name,
+		Addr:     r.Slave.Addr,
+		Group:    r.Slave.Group,
+		Status:   r.Status(),
+		Msg:      r.Msg,
+		Attempts: r.Attempts,
+		ExitCode: r.ExitCode,
+		Duration: r.Duration.Seconds(),
+		Outputs:  r.Outputs,
+		Uploads:  r.Uploads,
+
+		Signatures: r.Signatures,
+		Tail:       r.Tail,
+		Wave:       r.Wave,
+		ScriptRev:  r.ScriptRev,
+
+		Artifacts: r.Artifacts,
+
+		Rescheduled: r.Rescheduled,
+
+		Images: r.Images,
+
+		Ping: r.Ping.Seconds(),
+
+		ClockSkew: r.Skew.Seconds(),
+
+		FailedOutputs: r.FailedOutputs,
+
+		SBOM: r.SBOM,
+
+		Targets: r.Targets,
+
+		Inputs: r.Inputs,
+
+		Script:   r.Slave.LocalCommandFileName(),
+		Fallback: r.Fallback,
+
+		SysInfo: r.SysInfo,
+	}
+	if r.Err != nil {
+		rec.Error = r.Err.Error()
+	}
+	return rec
+}
+
+// validRunLabel returns whether label may be used as a -run-label, which
+// ends up in file names.
+func validRunLabel(label string) bool {
+	for _, c := range label {
+		switch {
+		case c == '.', c == '_', c == '-':
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// labelled returns the name of the report file fname for the -run-label of
+// the run, if any: report.json becomes report-<label>.json.
+func labelled(fname string) string {
+	if *g_run_label == "" {
+		return fname
+	}
+	ext := filepath.Ext(fname)
+	return strings.TrimSuffix(fname, ext) + "-" + *g_run_label + ext
+}
+
+// writeReportJSON writes the reports of all the slaves of the run as a
+// JSON array into fname (with -stats, as the "reports" of an object also
+// holding the "stats" of the fleet.)
+func writeReportJSON(fname string, reports []bldbot.BuildReport) error {
+	recs := make([]reportRecord, 0, len(reports))
+	for _, report := range reports {
+		recs = append(recs, record(report))
+	}
+	var v interface{} = recs
+	if *g_stats {
+		v = struct {
+			Reports []reportRecord `json:"reports"`
+			Stats   fleetStats     `json:"stats"`
+		}{recs, computeStats(reports)}
+	}
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fname, append(out, '\n'), 0644)
+}
+
+// manifestEntry is an output listed in the manifest of a run (-manifest-out.)
+type manifestEntry struct {
+	Label  string    `json:"label,omitempty"`
+	Slave  string    `json:"slave"`
+	Path   string    `json:"path"`
+	Size   int64     `json:"size"`
+	SHA256 string    `json:"sha256"`
+	Built  time.Time `json:"built"`
+}
+
+// writeManifest writes the manifest of all the outputs retrieved by the
+// successful builds as a JSON array into fname.
+func writeManifest(fname string, reports []bldbot.BuildReport) error {
+	entries := make([]manifestEntry, 0)
+	for _, report := range reports {
+		if report.Status() != bldbot.StatusOK {
+			continue
+		}
+		for _, a := range report.Artifacts {
+			entries = append(entries, manifestEntry{
+				Label:  *g_run_label,
+				Slave:  report.Slave.Name,
+				Path:   a.Path,
+				Size:   a.Size,
+				SHA256: a.SHA256,
+				Built:  report.Built,
+			})
+		}
+	}
+	out, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fname, append(out, '\n'), 0644)
+}
+
+// writeConsolidatedLog writes the logs of all the slaves into fname, one
+// slave after the other (in the order of the slaves list) so the output of
+// parallel builds is not interleaved.
+func writeConsolidatedLog(fname string, slaves []bldbot.Slave, builders []*bldbot.Builder, reports []bldbot.BuildReport) error {
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	logs := make(map[string]*bldbot.Builder, len(builders))
+	for _, builder := range builders {
+		logs[builder.Slave().Name] = builder
+	}
+	status := make(map[string]bldbot.BuildReport, len(reports))
+	for _, report := range reports {
+		status[report.Slave.Name] = report
+	}
+
+	for _, slave := range slaves {
+		report := status[slave.Name]
+		fmt.Fprintf(f, "#### slave [%s] (%s) -- %s: %s\n", slave.Name, slave.Addr, report.Status(), report.Msg)
+		builder, ok := logs[slave.Name]
+		if !ok {
+			continue
+		}
+		for _, name := range builder.LogFiles() {
+			err = appendFile(f, name)
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(f, "\n")
+	}
+	return f.Close()
+}
+
+// appendFile copies the content of the file fname to w.
+func appendFile(w io.Writer, fname string) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
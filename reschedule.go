@@ -0,0 +1,10 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+var g_reschedule = flag.Bool("reschedule-on-unreachable", false, "run again, later, the builds whose slave became unreachable during the run")
+var g_max_reschedules = flag.Int("max-reschedules", 3, "maximum number of times the build of a slave is rescheduled (see -reschedule-on-unreachable)")
+var g_reschedule_delay = flag.Duration("reschedule-delay", 30*time.Second, "delay before a rescheduled build is run again (see -reschedule-on-unreachable)")
@@ -0,0 +1,7 @@
+package main
+
+import (
+	"flag"
+)
+
+var g_sbom_tool = flag.String("sbom-tool", "", "local command run with the retrieved outputs as arguments, whose output is stored as the SBOM (<slave>.sbom.json) of the builds of the slaves without an SBOMCommand")
@@ -0,0 +1,8 @@
+package main
+
+import (
+	"flag"
+)
+
+var g_clock_skew = flag.Duration("clock-skew", 0, "maximum difference between the clocks of the slaves and the local one, warned about above (0: not checked)")
+var g_clock_skew_fail = flag.Bool("clock-skew-fail", false, "fail the build of a slave whose clock skew is above -clock-skew, instead of warning")
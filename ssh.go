@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+)
+
+var g_ssh_compression = flag.Bool("ssh-compression", false, "enable compression on all ssh/scp connections")
+var g_transport = flag.String("transport", "scp", "how files are copied to/from the slaves: scp, tar (over ssh), auto (tar if scp is not available) or rsync (delta transfers)")
+var g_cipher = flag.String("cipher", "", "cipher(s) used by all ssh/scp connections (availability depends on the local OpenSSH)")
+var g_bind_address = flag.String("bind-address", "", "local (source) address all ssh/scp/rsync connections to the slaves originate from, on multi-homed hosts")
+var g_connect_rate = flag.Int("connect-rate", 0, "maximum number of ssh/scp/rsync connections opened per second, across all the slaves (0: unlimited)")
+
+// sshFlagOptions returns the command line options shared by all the ssh
+// and scp invocations, as set by the flags.
+func sshFlagOptions() []string {
+	opts := make([]string, 0, 5)
+	if *g_ssh_compression {
+		opts = append(opts, "-C")
+	}
+	if *g_cipher != "" {
+		opts = append(opts, "-c", *g_cipher)
+	}
+	if *g_bind_address != "" {
+		// (unlike -b, understood by both ssh and scp.)
+		opts = append(opts, "-o", "BindAddress="+*g_bind_address)
+	}
+	return opts
+}
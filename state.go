@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+var g_state_file = flag.String("state-file", "", "(JSON) file where the last status of each slave is persisted across runs")
+var g_transition_hook = flag.String("transition-hook", "", "command run (with the JSON transition on stdin) when a slave goes from ok to failed, or back (requires -state-file)")
+var g_transition_webhook = flag.String("transition-webhook", "", "URL the JSON transition is POSTed to when a slave goes from ok to failed, or back (requires -state-file)")
+
+// Transition types.
+const (
+	TransitionBroke     = "broke"     // ok -> failed
+	TransitionRecovered = "recovered" // failed -> ok
+)
+
+// slaveState is the persisted status of a slave (-state-file.)
+type slaveState struct {
+	Status string    `json:"status"` // StatusOK or StatusFailed
+	Msg    string    `json:"msg"`
+	Since  time.Time `json:"since"` // time of the last transition
+
+	// OutputBytes is the total size of the outputs retrieved by the last
+	// successful build (see -estimate), Outputs their local paths (the
+	// inputs of the dependents built without it, see DependsOn.)
+	OutputBytes int64    `json:"output_bytes,omitempty"`
+	Outputs     []string `json:"outputs,omitempty"`
+}
+
+// states maps a slave name to its persisted state.
+type states map[string]slaveState
+
+// transition is the payload sent to the -transition-hook/-webhook.
+type transition struct {
+	Slave      string    `json:"slave"`
+	Addr       string    `json:"addr"`
+	Transition string    `json:"transition"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	Msg        string    `json:"msg"`
+	Since      time.Time `json:"since"` // time the slave entered the From state
+	Time       time.Time `json:"time"`
+}
+
+// loadStates reads the state file fname. A missing file yields no state.
+func loadStates(fname string) (states, error) {
+	st := make(states)
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return st, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(buf, &st)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (st states) save(fname string) error {
+	buf, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fname, append(buf, '\n'), 0644)
+}
+
+// update records the outcome of the builds and returns the transitions
+// from the previously persisted states.
+// Cancelled and aborted builds leave the state of their slave unchanged,
+// unreachable slaves count as failed. A slave without persisted state
+// yields no transition.
+func (st states) update(reports []bldbot.BuildReport) []transition {
+	var trs []transition
+	now := time.Now()
+	for _, report := range reports {
+		status := report.Status()
+		switch status {
+		case bldbot.StatusOK:
+		case bldbot.StatusFailed, bldbot.StatusUnreachable:
+			status = bldbot.StatusFailed
+		default:
+			continue
+		}
+		name := report.Slave.Name
+		prev, known := st[name]
+		cur := slaveState{Status: status, Msg: report.Msg, Since: prev.Since, OutputBytes: prev.OutputBytes, Outputs: prev.Outputs}
+		if !known || prev.Status != status {
+			cur.Since = now
+		}
+		if status == bldbot.StatusOK && !report.Collected {
+			cur.OutputBytes = 0
+			for _, a := range report.Artifacts {
+				cur.OutputBytes += a.Size
+			}
+		}
+		if status == bldbot.StatusOK && report.InOutputDir() {
+			cur.Outputs = report.Outputs
+		}
+		st[name] = cur
+		if !known || prev.Status == status {
+			continue
+		}
+		tr := transition{
+			Slave:      name,
+			Addr:       report.Slave.Addr,
+			Transition: TransitionBroke,
+			From:       prev.Status,
+			To:         status,
+			Msg:        report.Msg,
+			Since:      prev.Since,
+			Time:       now,
+		}
+		if status == bldbot.StatusOK {
+			tr.Transition = TransitionRecovered
+		}
+		trs = append(trs, tr)
+	}
+	return trs
+}
+
+// notify sends the transition to the -transition-hook and -transition-webhook.
+func (tr transition) notify() error {
+	buf, err := json.Marshal(tr)
+	if err != nil {
+		return err
+	}
+
+	if *g_transition_hook != "" {
+		cmd := exec.Command("/bin/sh", "-c", *g_transition_hook)
+		cmd.Stdin = bytes.NewReader(buf)
+		cmd.Env = append(os.Environ(),
+			"BLDBOT_SLAVE="+tr.Slave,
+			"BLDBOT_TRANSITION="+tr.Transition,
+		)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("transition hook failed (%v: %s)", err, string(out))
+		}
+	}
+
+	if *g_transition_webhook != "" {
+		client := http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Post(*g_transition_webhook, "application/json", bytes.NewReader(buf))
+		if err != nil {
+			return fmt.Errorf("transition webhook failed (%v)", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("transition webhook failed (%s)", resp.Status)
+		}
+	}
+	return nil
+}
+
+// updateStates updates the -state-file with the outcome of the builds and
+// notifies the state transitions of the slaves.
+func updateStates(fname string, reports []bldbot.BuildReport) error {
+	st, err := loadStates(fname)
+	if err != nil {
+		return err
+	}
+	for _, tr := range st.update(reports) {
+		summaryf(">>> slave [%s] %s (%s -> %s)\n", tr.Slave, tr.Transition, tr.From, tr.To)
+		err := tr.notify()
+		if err != nil {
+			log.Printf("slave [%s]: %v\n", tr.Slave, err)
+		}
+	}
+	return st.save(fname)
+}
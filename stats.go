@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+var g_stats = flag.Bool("stats", false, "print statistics over the builds of the fleet (success rate, durations, bytes retrieved) at the end of the run, and add them to the -report-json")
+
+// fleetStats aggregates the reports of a run (-stats.) Durations are in
+// seconds, over the builds whose build-script ran.
+type fleetStats struct {
+	Builds      int     `json:"builds"`       // builds run (not skipped by their Condition, nor detached)
+	OK          int     `json:"ok"`           // successful builds
+	SuccessRate float64 `json:"success_rate"` // OK/Builds (0 without any build)
+	Bytes       int64   `json:"bytes"`        // total size of the retrieved outputs
+
+	MedianDuration float64 `json:"median_duration,omitempty"`
+	P95Duration    float64 `json:"p95_duration,omitempty"`
+
+	Fastest         string  `json:"fastest,omitempty"`
+	FastestDuration float64 `json:"fastest_duration,omitempty"`
+	Slowest         string  `json:"slowest,omitempty"`
+	SlowestDuration float64 `json:"slowest_duration,omitempty"`
+}
+
+// computeStats returns the statistics of the fleet over the reports.
+func computeStats(reports []bldbot.BuildReport) fleetStats {
+	var st fleetStats
+	timed := make([]bldbot.BuildReport, 0, len(reports))
+	for _, report := range reports {
+		if report.Skipped || report.Detached {
+			continue
+		}
+		st.Builds++
+		if report.Status() == bldbot.StatusOK {
+			st.OK++
+		}
+		for _, a := range report.Artifacts {
+			st.Bytes += a.Size
+		}
+		if report.Duration > 0 {
+			timed = append(timed, report)
+		}
+	}
+	if st.Builds > 0 {
+		st.SuccessRate = float64(st.OK) / float64(st.Builds)
+	}
+	if len(timed) <= 0 {
+		return st
+	}
+
+	sort.SliceStable(timed, func(i, j int) bool {
+		return timed[i].Duration < timed[j].Duration
+	})
+	st.MedianDuration = percentile(timed, 0.50).Seconds()
+	st.P95Duration = percentile(timed, 0.95).Seconds()
+	st.Fastest = timed[0].Slave.Name
+	st.FastestDuration = timed[0].Duration.Seconds()
+	st.Slowest = timed[len(timed)-1].Slave.Name
+	st.SlowestDuration = timed[len(timed)-1].Duration.Seconds()
+	return st
+}
+
+// percentile returns the (nearest-rank) p-th percentile of the durations of
+// the reports, sorted by duration.
+func percentile(sorted []bldbot.BuildReport, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i].Duration
+}
+
+// printStats prints the statistics of the fleet.
+func printStats(st fleetStats) {
+	seconds := func(s float64) time.Duration {
+		return (time.Duration(s * float64(time.Second))).Round(time.Millisecond)
+	}
+	summaryf(">>> fleet stats:\n")
+	summaryf(" success rate: %.1f%% (%d/%d)\n", 100*st.SuccessRate, st.OK, st.Builds)
+	summaryf(" retrieved:    %s\n", bldbot.HumanSize(st.Bytes))
+	if st.Fastest == "" {
+		return
+	}
+	summaryf(" duration:     median %v, p95 %v\n", seconds(st.MedianDuration), seconds(st.P95Duration))
+	summaryf(" fastest:      %s (%v)\n", st.Fastest, seconds(st.FastestDuration))
+	summaryf(" slowest:      %s (%v)\n", st.Slowest, seconds(st.SlowestDuration))
+}
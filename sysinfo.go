@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// mapFlag is a repeatable flag holding name=value pairs.
+type mapFlag map[string]string
+
+func (f *mapFlag) String() string {
+	res := make([]string, 0, len(*f))
+	for k, v := range *f {
+		res = append(res, k+"="+v)
+	}
+	sort.Strings(res)
+	return strings.Join(res, ",")
+}
+
+func (f *mapFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("invalid name=value pair %q", s)
+	}
+	if *f == nil {
+		*f = make(mapFlag)
+	}
+	(*f)[s[:i]] = s[i+1:]
+	return nil
+}
@@ -0,0 +1,7 @@
+package main
+
+import (
+	"flag"
+)
+
+var g_syslog = flag.String("syslog", "", "syslog server ([udp|tcp://]host:port, or 'local') the build output is also sent to")
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+var g_trace = flag.String("trace", "", "file where to write the timeline of the phases of all the builds (Chrome trace format)")
+
+// traceEvent is an event of the Chrome trace format (as loaded by
+// chrome://tracing or Perfetto): a complete ("X") event is a phase of the
+// build of a slave, a metadata ("M") event names the row of a slave.
+type traceEvent struct {
+	Name string            `json:"name"`
+	Cat  string            `json:"cat,omitempty"`
+	Ph   string            `json:"ph"`
+	Ts   int64             `json:"ts"` // in microseconds since the start of the run
+	Dur  int64             `json:"dur,omitempty"`
+	Pid  int               `json:"pid"`
+	Tid  int               `json:"tid"`
+	Args map[string]string `json:"args,omitempty"`
+}
+
+// tracer records the phases of the builds from the orchestration events.
+type tracer struct {
+	fname  string
+	start  time.Time
+	tids   map[string]int       // slave -> row
+	phase  map[string]string    // slave -> current phase
+	since  map[string]time.Time // slave -> start of its current phase
+	events []traceEvent
+}
+
+// traceEventSink returns an event sink writing the timeline of the run into
+// fname (that of the stage, within a -pipeline) once it completed.
+func traceEventSink(fname string) func(bldbot.Event) {
+	t := &tracer{fname: fname}
+	t.reset()
+	return t.handle
+}
+
+func (t *tracer) reset() {
+	t.start = time.Now()
+	t.tids = make(map[string]int)
+	t.phase = make(map[string]string)
+	t.since = make(map[string]time.Time)
+	t.events = nil
+}
+
+func (t *tracer) handle(ev bldbot.Event) {
+	switch ev.Type {
+	case bldbot.EventSlaveStarted:
+		t.tid(ev.Slave)
+	case bldbot.EventPhase:
+		t.end(ev.Slave, ev.Time)
+		t.phase[ev.Slave] = ev.Phase
+		t.since[ev.Slave] = ev.Time
+	case bldbot.EventSlaveCompleted:
+		t.end(ev.Slave, ev.Time)
+	case bldbot.EventRunCompleted:
+		fname := bldbot.StageName(t.fname, pipelineStage)
+		err := t.write(fname)
+		if err != nil {
+			log.Printf("could not write trace [%s] (err=%v)\n", fname, err)
+		}
+		t.reset()
+	}
+}
+
+// tid returns the row of the slave in the timeline.
+func (t *tracer) tid(slave string) int {
+	tid, ok := t.tids[slave]
+	if !ok {
+		tid = len(t.tids) + 1
+		t.tids[slave] = tid
+		t.events = append(t.events, traceEvent{
+			Name: "thread_name",
+			Ph:   "M",
+			Pid:  1,
+			Tid:  tid,
+			Args: map[string]string{"name": slave},
+		})
+	}
+	return tid
+}
+
+// end records the current phase of the slave, if any, as ended at now.
+func (t *tracer) end(slave string, now time.Time) {
+	phase, ok := t.phase[slave]
+	if !ok {
+		return
+	}
+	since := t.since[slave]
+	t.events = append(t.events, traceEvent{
+		Name: phase,
+		Cat:  slave,
+		Ph:   "X",
+		Ts:   since.Sub(t.start).Microseconds(),
+		Dur:  now.Sub(since).Microseconds(),
+		Pid:  1,
+		Tid:  t.tid(slave),
+	})
+	delete(t.phase, slave)
+	delete(t.since, slave)
+}
+
+func (t *tracer) write(fname string) error {
+	out, err := json.Marshal(struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}{t.events})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fname, append(out, '\n'), 0644)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+// execCommandHook returns a command hook delegating to the program prog:
+// it is run (through the shell) with the JSON operation on stdin, and
+// prints the command line to run as a JSON array of strings on stdout.
+func execCommandHook(prog string) bldbot.CommandHook {
+	return func(op bldbot.Operation) ([]string, error) {
+		in, err := json.Marshal(op)
+		if err != nil {
+			return nil, err
+		}
+		cmd := exec.Command("/bin/sh", "-c", prog)
+		cmd.Stdin = bytes.NewReader(in)
+		stderr := new(bytes.Buffer)
+		cmd.Stderr = stderr
+		out, err := cmd.Output()
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				err = fmt.Errorf("%v: %s", err, msg)
+			}
+			return nil, err
+		}
+		var argv []string
+		err = json.Unmarshal(out, &argv)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output %q (%v)", out, err)
+		}
+		return argv, nil
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+// tuiRefresh is the period at which the dashboard is redrawn.
+const tuiRefresh = 250 * time.Millisecond
+
+// dashboard is the live dashboard of the run (-tui), if any.
+var dashboard *tui
+
+// tuiRow is the state of a slave shown by the dashboard.
+type tuiRow struct {
+	name   string
+	status string // "pending", "running" or the Status* of the build
+	phase  string
+	start  time.Time
+	end    time.Time
+	line   string // last line of the build output
+}
+
+// tui is a terminal dashboard showing the live state of the builds, built
+// on the orchestration events.
+// While it runs, the output of the run is held back and printed once the
+// dashboard is closed.
+type tui struct {
+	mu     sync.Mutex
+	rows   []*tuiRow
+	byName map[string]*tuiRow
+	top    int // index of the first row shown (scrolling)
+	start  time.Time
+
+	tty    *os.File // the terminal (the original stdout)
+	stderr *os.File
+	stty   string   // terminal settings to restore, if changed
+	pipe   *os.File // write end of the pipe holding back the output
+	held   bytes.Buffer
+	drain  chan struct{}
+	quit   chan struct{}
+	done   chan struct{}
+	sigs   chan os.Signal
+}
+
+// startTUI starts the dashboard for the given slaves.
+// It returns nil if stdout is not a terminal. While it runs, an interrupted
+// run closes it and calls interrupted (e.g. releasing the -lock-file), if
+// set, before exiting.
+func startTUI(slaves []bldbot.Slave, interrupted func()) *tui {
+	fi, err := os.Stdout.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		log.Printf("buildbot: stdout is not a terminal, ignoring -tui\n")
+		return nil
+	}
+
+	t := &tui{
+		byName: make(map[string]*tuiRow),
+		start:  time.Now(),
+		tty:    os.Stdout,
+		stderr: os.Stderr,
+		drain:  make(chan struct{}),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+		sigs:   make(chan os.Signal, 1),
+	}
+	for _, slave := range slaves {
+		row := &tuiRow{name: slave.Name, status: "pending"}
+		t.rows = append(t.rows, row)
+		t.byName[slave.Name] = row
+	}
+
+	// hold back the output of the run.
+	r, w, err := os.Pipe()
+	if err != nil {
+		log.Printf("buildbot: could not start the dashboard (%v)\n", err)
+		return nil
+	}
+	t.pipe = w
+	os.Stdout = w
+	os.Stderr = w
+	log.SetOutput(w)
+	go func() {
+		io.Copy(&t.held, r)
+		r.Close()
+		close(t.drain)
+	}()
+
+	// read the scrolling keys, without waiting for a newline.
+	if out, err := t.sttyCmd("-g"); err == nil {
+		t.stty = strings.TrimSpace(out)
+		t.sttyCmd("-icanon", "-echo", "min", "1")
+		go t.keys()
+	}
+
+	signal.Notify(t.sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-t.sigs; !ok {
+			return
+		}
+		t.stop()
+		if interrupted != nil {
+			interrupted()
+		}
+		os.Exit(130)
+	}()
+
+	fmt.Fprintf(t.tty, "\x1b[?1049h\x1b[?25l") // alternate screen, hide cursor
+	addEventSink(t.event)
+	go t.loop()
+	return t
+}
+
+// stop closes the dashboard and prints the output held back while it ran.
+func (t *tui) stop() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	select {
+	case <-t.quit:
+		t.mu.Unlock()
+		return
+	default:
+		close(t.quit)
+	}
+	t.mu.Unlock()
+	<-t.done
+
+	// the signals are no longer handled by the dashboard.
+	signal.Stop(t.sigs)
+	close(t.sigs)
+
+	fmt.Fprintf(t.tty, "\x1b[?25h\x1b[?1049l") // show cursor, main screen
+	if t.stty != "" {
+		t.sttyCmd(t.stty)
+	}
+	os.Stdout = t.tty
+	os.Stderr = t.stderr
+	log.SetOutput(t.stderr)
+	t.pipe.Close()
+	<-t.drain
+	t.tty.Write(t.held.Bytes())
+}
+
+func (t *tui) sttyCmd(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return "", err
+	}
+	defer tty.Close()
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// event updates the dashboard from an orchestration event.
+func (t *tui) event(ev bldbot.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	row := t.byName[ev.Slave]
+	if row == nil {
+		return
+	}
+	switch ev.Type {
+	case bldbot.EventSlaveStarted:
+		row.status = "running"
+		row.start = ev.Time
+	case bldbot.EventPhase:
+		row.phase = ev.Phase
+	case bldbot.EventSlaveCompleted:
+		row.status = ev.Status
+		row.end = ev.Time
+		if ev.Status != bldbot.StatusOK && ev.Msg != "" {
+			row.line = ev.Msg
+		}
+	}
+}
+
+// output returns a writer recording the last line of the build output of
+// the slave name.
+func (t *tui) output(name string) io.Writer {
+	return tuiOutput{t: t, name: name}
+}
+
+type tuiOutput struct {
+	t    *tui
+	name string
+}
+
+func (o tuiOutput) Write(data []byte) (int, error) {
+	lines := strings.FieldsFunc(string(data), func(r rune) bool {
+		return r == '\n' || r == '\r'
+	})
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			continue
+		}
+		o.t.mu.Lock()
+		if row := o.t.byName[o.name]; row != nil {
+			row.line = line
+		}
+		o.t.mu.Unlock()
+		break
+	}
+	return len(data), nil
+}
+
+// keys scrolls the dashboard on j/k, the arrows and page up/down.
+func (t *tui) keys() {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return
+	}
+	defer tty.Close()
+	buf := make([]byte, 16)
+	for {
+		n, err := tty.Read(buf)
+		if err != nil {
+			return
+		}
+		_, height := t.size()
+		page := height - 4
+		delta := 0
+		switch key := string(buf[:n]); key {
+		case "j", "\x1b[B":
+			delta = 1
+		case "k", "\x1b[A":
+			delta = -1
+		case " ", "\x1b[6~":
+			delta = page
+		case "b", "\x1b[5~":
+			delta = -page
+		}
+		t.mu.Lock()
+		t.top += delta
+		if t.top > len(t.rows)-1 {
+			t.top = len(t.rows) - 1
+		}
+		if t.top < 0 {
+			t.top = 0
+		}
+		t.mu.Unlock()
+	}
+}
+
+func (t *tui) loop() {
+	defer close(t.done)
+	tick := time.NewTicker(tuiRefresh)
+	defer tick.Stop()
+	for {
+		t.draw()
+		select {
+		case <-t.quit:
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// size returns the size of the terminal (80x24 if unknown.)
+func (t *tui) size() (int, int) {
+	out, err := t.sttyCmd("size")
+	if err == nil {
+		f := strings.Fields(out)
+		if len(f) == 2 {
+			h, err1 := strconv.Atoi(f[0])
+			w, err2 := strconv.Atoi(f[1])
+			if err1 == nil && err2 == nil && w > 0 && h > 0 {
+				return w, h
+			}
+		}
+	}
+	return 80, 24
+}
+
+func (t *tui) draw() {
+	width, height := t.size()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	counts := make(map[string]int)
+	for _, row := range t.rows {
+		counts[row.status]++
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString("\x1b[H\x1b[2J")
+	header := fmt.Sprintf(
+		"buildbot -- %s -- %d slaves: %d pending, %d running, %d ok, %d skipped, %d failed",
+		now.Sub(t.start).Truncate(time.Second), len(t.rows),
+		counts["pending"], counts["running"], counts[bldbot.StatusOK], counts[bldbot.StatusSkipped],
+		len(t.rows)-counts["pending"]-counts["running"]-counts[bldbot.StatusOK]-counts[bldbot.StatusSkipped],
+	)
+	fmt.Fprintf(buf, "\x1b[1m%s\x1b[0m\r\n", clip(header, width))
+	fmt.Fprintf(buf, "%s\r\n", clip(fmt.Sprintf("%-20s %-12s %-9s %8s  %s", "SLAVE", "STATUS", "PHASE", "ELAPSED", "LAST LINE"), width))
+
+	rows := height - 3
+	if rows < 1 {
+		rows = 1
+	}
+	for i := t.top; i < len(t.rows) && i < t.top+rows; i++ {
+		row := t.rows[i]
+		elapsed := ""
+		if !row.start.IsZero() {
+			end := row.end
+			if end.IsZero() {
+				end = now
+			}
+			elapsed = end.Sub(row.start).Truncate(time.Second).String()
+		}
+		color := ""
+		switch row.status {
+		case bldbot.StatusOK:
+			color = "\x1b[32m"
+		case "running":
+			color = "\x1b[33m"
+		case bldbot.StatusSkipped:
+			color = "\x1b[36m"
+		case "pending":
+		default:
+			color = "\x1b[31m"
+		}
+		line := fmt.Sprintf("%-20s %-12s %-9s %8s  %s", row.name, row.status, row.phase, elapsed, row.line)
+		fmt.Fprintf(buf, "%s%s\x1b[0m\r\n", color, clip(line, width))
+	}
+	fmt.Fprintf(buf, "\x1b[%d;1H%s", height, clip(fmt.Sprintf(
+		"rows %d-%d of %d -- j/k, space/b to scroll",
+		t.top+1, min(t.top+rows, len(t.rows)), len(t.rows),
+	), width))
+	t.tty.Write(buf.Bytes())
+}
+
+// clip truncates s to (at most) n runes.
+func clip(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
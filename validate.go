@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+var g_validate_remote = flag.Bool("validate-remote", false, "check that the work and output directories can be created and written on each slave (then remove them) and exit, without running any build")
+
+// validateScript creates the work directory (%[1]s) and the output directory
+// (%[2]s) of a build if they do not exist, writes a probe file into each, and
+// removes what it created. The first check which failed is reported.
+const validateScript = `d=%[1]s; o=%[2]s
+fail() { echo "$1"; cleanup; exit 1; }
+cleanup() {
+	if [ -n "$newd" ]; then rm -rf "$d"; elif [ -n "$newo" ]; then rm -rf "$o"; fi
+}
+newd=; newo=
+if [ ! -d "$d" ]; then
+	mkdir -p "$d" 2>/dev/null || fail "cannot create work directory $d"
+	newd=1
+fi
+head -c 1024 /dev/zero > "$d/.go-bldbot-probe" 2>/dev/null || fail "cannot write into work directory $d"
+rm -f "$d/.go-bldbot-probe" || fail "cannot remove from work directory $d"
+if [ ! -d "$o" ]; then
+	mkdir -p "$o" 2>/dev/null || fail "cannot create output directory $o"
+	newo=1
+fi
+: > "$o/.go-bldbot-probe" 2>/dev/null || fail "cannot write into output directory $o"
+rm -f "$o/.go-bldbot-probe" || fail "cannot remove from output directory $o"
+cleanup
+`
+
+// validateRemote checks (in parallel) the remote directories of the builds
+// of the slaves, prints the outcome for each slave and returns the exit code
+// of the run: 0 if they all passed, 1 otherwise.
+func validateRemote(slaves []bldbot.Slave) int {
+	errs := make([]error, len(slaves))
+	var wg sync.WaitGroup
+	for i := range slaves {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = validateSlave(slaves[i])
+		}(i)
+	}
+	wg.Wait()
+
+	summaryf(">>> remote validation:\n")
+	passed := 0
+	for i := range slaves {
+		if errs[i] != nil {
+			summaryf(" %s: FAILED (%v)\n", slaves[i].Label(), errs[i])
+			continue
+		}
+		passed++
+		summaryf(" %s: ok\n", slaves[i].Label())
+	}
+	summaryf(">>> remote validation: %d/%d slave(s) passed\n", passed, len(slaves))
+	if passed < len(slaves) {
+		return 1
+	}
+	return 0
+}
+
+// validateSlave checks that the work directory of the next build of the
+// slave and its output directory can be created and written. Only what did
+// not exist before is removed afterwards.
+func validateSlave(slave bldbot.Slave) error {
+	if !slave.IsUnix() {
+		return fmt.Errorf("not supported on %s slaves", slave.OS)
+	}
+	err := slave.Ping(flagOptions())
+	if err != nil {
+		return err
+	}
+	slave.Path, err = slave.WorkDir(*g_persistent_workdir)
+	if err != nil {
+		return err
+	}
+	err = bldbot.CheckRemotePath(slave.Path)
+	if err != nil {
+		return err
+	}
+	odir, err := slave.RemoteOutputDir()
+	if err != nil {
+		return err
+	}
+
+	out := new(bytes.Buffer)
+	cmd := fmt.Sprintf(validateScript, bldbot.ShellQuote(slave.Path), bldbot.ShellQuote(odir))
+	err = bldbot.NewTransport(&slave, flagOptions()).Run(context.Background(), cmd, nil, out, out)
+	if err != nil {
+		msg := strings.TrimSpace(out.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("%s", msg)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+var g_wait_for_slaves = flag.Duration("wait-for-slaves", 0, "how long to wait for the unreachable slaves to come up before the builds (0: do not wait)")
+var g_quorum = flag.Int("quorum", 0, "minimum number of reachable slaves needed to run the builds (0: any)")
+
+// waitPoll is the period at which the slaves not yet up are pinged again.
+const waitPoll = 2 * time.Second
+
+// waitForSlaves pings the slaves (in parallel) until they are all up or the
+// deadline wait elapsed, and returns the outcome of the last ping of each
+// slave, by name.
+func waitForSlaves(slaves []bldbot.Slave, wait time.Duration) map[string]bldbot.PingResult {
+	start := time.Now()
+	deadline := start.Add(wait)
+	pings := make(map[string]bldbot.PingResult, len(slaves))
+	late := make([]string, 0)
+	pending := slaves
+	for round := 0; ; round++ {
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		down := make([]bldbot.Slave, 0, len(pending))
+		for i := range pending {
+			wg.Add(1)
+			go func(slave *bldbot.Slave) {
+				defer wg.Done()
+				ping := slave.TimedPing(flagOptions())
+				mu.Lock()
+				defer mu.Unlock()
+				pings[slave.Name] = ping
+				if ping.Err != nil {
+					down = append(down, *slave)
+				} else if round > 0 {
+					late = append(late, slave.Name+" after "+time.Since(start).Round(time.Second).String())
+				}
+			}(&pending[i])
+		}
+		wg.Wait()
+		pending = down
+		if len(pending) == 0 || !time.Now().Add(waitPoll).Before(deadline) {
+			break
+		}
+		if round == 0 {
+			progressf(">>> waiting (up to %v) for %d unreachable slave(s)...\n", wait, len(pending))
+		}
+		time.Sleep(waitPoll)
+	}
+
+	if len(late) > 0 || len(pending) > 0 {
+		msg := ""
+		if len(late) > 0 {
+			msg = " (late: " + strings.Join(late, ", ") + ")"
+		}
+		summaryf(">>> waited %v for the slaves, %d still unreachable%s\n",
+			time.Since(start).Round(time.Second), len(pending), msg,
+		)
+	}
+	return pings
+}
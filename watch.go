@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gogenesis/go-bldbot/bldbot"
+)
+
+const (
+	watchPoll     = 500 * time.Millisecond // interval between two scans, when polling
+	watchDebounce = 1 * time.Second        // quiet period before a rebuild
+)
+
+// watch watches the local directories of the slaves (build-script and
+// inputs) and re-runs the builds of the slaves whose files changed, holding
+// the -lock-file during each rebuild only.
+// The directories are watched through filesystem notifications where
+// supported (see watchDirs), polled every watchPoll otherwise. Successive
+// changes are debounced: a slave is rebuilt once its files did not change
+// for watchDebounce.
+// watch never returns.
+func watch(slaves []bldbot.Slave, ctl *controller) {
+	dirs := make([]string, 0, len(slaves))
+	for _, slave := range slaves {
+		dirs = append(dirs, slave.Name)
+	}
+	changes, err := watchDirs(dirs)
+	if err != nil {
+		log.Printf("buildbot: could not watch the directories of the slaves (%v), polling them\n", err)
+		changes = pollDirs(dirs)
+	}
+	progressf(">>> watching for changes...\n")
+
+	tick := time.NewTicker(watchDebounce / 4)
+	defer tick.Stop()
+	dirty := make(map[string]time.Time) // slave name -> last change
+	for {
+		select {
+		case name := <-changes:
+			dirty[name] = time.Now()
+			continue
+		case <-tick.C:
+		}
+
+		now := time.Now()
+		todo := make([]bldbot.Slave, 0, len(dirty))
+		for _, slave := range slaves {
+			last, ok := dirty[slave.Name]
+			if !ok || now.Sub(last) < watchDebounce {
+				continue
+			}
+			todo = append(todo, slave)
+		}
+		if len(todo) <= 0 {
+			continue
+		}
+
+		names := make([]string, 0, len(todo))
+		for _, slave := range todo {
+			names = append(names, slave.Name)
+		}
+		sort.Strings(names)
+		var lock *fleetLock
+		if *g_lock_file != "" {
+			lock, err = acquireLock(*g_lock_file, *g_lock_wait, *g_lock_max_age)
+			if err != nil {
+				// tried again on the next tick.
+				progressf(">>> changes detected for %v, not rebuilding (%v)\n", names, err)
+				continue
+			}
+		}
+		for _, slave := range todo {
+			delete(dirty, slave.Name)
+		}
+		progressf(">>> changes detected for %v, rebuilding...\n", names)
+		runSlaves(todo, ctl)
+		lock.release()
+		progressf(">>> watching for changes...\n")
+	}
+}
+
+// pollDirs scans the files under the directories dirs every watchPoll, and
+// sends on the returned channel the directories whose files changed.
+func pollDirs(dirs []string) <-chan string {
+	changes := make(chan string, len(dirs))
+	go func() {
+		stamps := make(map[string]map[string]time.Time, len(dirs))
+		for _, dir := range dirs {
+			stamps[dir] = scanDir(dir)
+		}
+		for {
+			time.Sleep(watchPoll)
+			for _, dir := range dirs {
+				cur := scanDir(dir)
+				if !sameStamps(stamps[dir], cur) {
+					changes <- dir
+				}
+				stamps[dir] = cur
+			}
+		}
+	}()
+	return changes
+}
+
+// scanDir returns the modification times of all the files under dir.
+func scanDir(dir string) map[string]time.Time {
+	stamps := make(map[string]time.Time)
+	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !fi.IsDir() {
+			stamps[path] = fi.ModTime()
+		}
+		return nil
+	})
+	return stamps
+}
+
+func sameStamps(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !w.Equal(v) {
+			return false
+		}
+	}
+	return true
+}
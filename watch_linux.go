@@ -0,0 +1,112 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask is the set of the inotify events which are changes.
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_ATTRIB | syscall.IN_DELETE_SELF
+
+// inotifyWatch is what a watch descriptor watches: the directory path,
+// under the watched directory root.
+type inotifyWatch struct {
+	root string
+	path string
+}
+
+// watchDirs watches the directories dirs (and their sub-directories, even
+// those created later) with inotify, and sends on the returned channel the
+// directories whose files changed.
+func watchDirs(dirs []string) (<-chan string, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("inotify: %v", err)
+	}
+	watches := make(map[int32]inotifyWatch)
+	for _, dir := range dirs {
+		err = addWatches(fd, watches, dir, dir)
+		if err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+	}
+
+	changes := make(chan string, len(dirs))
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := syscall.Read(fd, buf)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil || n <= 0 {
+				// changes are not seen any more: poll instead.
+				for dir := range pollDirs(dirs) {
+					changes <- dir
+				}
+				return
+			}
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+				off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+				if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+					// events were lost.
+					for _, dir := range dirs {
+						changes <- dir
+					}
+					continue
+				}
+				w, ok := watches[ev.Wd]
+				if !ok {
+					continue
+				}
+				if ev.Mask&syscall.IN_ISDIR != 0 && ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					sub := filepath.Join(w.path, string(bytes.TrimRight(name, "\x00")))
+					addWatches(fd, watches, w.root, sub)
+				}
+				if ev.Mask&syscall.IN_IGNORED != 0 {
+					delete(watches, ev.Wd)
+				}
+				if ev.Mask&inotifyMask != 0 {
+					changes <- w.root
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// addWatches watches the directory dir, under root, and all its
+// sub-directories. A missing root is not watched.
+func addWatches(fd int, watches map[int32]inotifyWatch, root, dir string) error {
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if path == dir {
+				return nil
+			}
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(fd, path, inotifyMask)
+		if err != nil {
+			return fmt.Errorf("inotify: could not watch [%s] (%v)", path, err)
+		}
+		watches[int32(wd)] = inotifyWatch{root: root, path: path}
+		return nil
+	})
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// watchDirs polls the directories dirs (there are no filesystem
+// notifications on this system), see pollDirs.
+func watchDirs(dirs []string) (<-chan string, error) {
+	return pollDirs(dirs), nil
+}